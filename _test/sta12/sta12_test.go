@@ -11,6 +11,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/mattn/go-sqlite3"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
@@ -84,7 +85,7 @@ func TestStation12(t *testing.T) {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
 			svc := service.NewTODOService(d)
-			got, err := svc.UpdateTODO(context.Background(), tc.ID, tc.Subject, tc.Description)
+			got, err := svc.UpdateTODO(auth.ContextInternal(context.Background()), tc.ID, tc.Subject, tc.Description)
 			switch tc.WantError {
 			case nil:
 				if err != nil {
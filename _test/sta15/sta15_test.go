@@ -8,6 +8,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
@@ -115,7 +116,7 @@ func TestStation15(t *testing.T) {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
 			svc := service.NewTODOService(d)
-			ret, err := svc.ReadTODO(context.Background(), tc.PrevID, tc.Size)
+			ret, err := svc.ReadTODO(auth.ContextInternal(context.Background()), tc.PrevID, tc.Size)
 			if err != nil {
 				t.Errorf("ReadTODOに失敗しました: %v", err)
 				return
@@ -8,6 +8,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/service"
 	"github.com/TechBowl-japan/go-stations/handler"
@@ -61,7 +62,10 @@ func TestStation16(t *testing.T) {
 	r := router.NewRouter(todoDB)
 	h := handler.NewTODOHandler(service.NewTODOService(todoDB))
 	r.Handle("/todos", h)
-	srv := httptest.NewServer(r)
+	// the stations predate users, so the requests act as the server itself
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.ServeHTTP(w, req.WithContext(auth.ContextInternal(req.Context())))
+	}))
 	defer srv.Close()
 
 	testcases := map[string]struct {
@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
@@ -95,7 +96,7 @@ func TestStation18(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			err := service.NewTODOService(todoDB).DeleteTODO(context.Background(), tc.IDs)
+			err := service.NewTODOService(todoDB).DeleteTODO(auth.ContextInternal(context.Background()), tc.IDs)
 
 			switch tc.WantError {
 			case nil:
@@ -11,6 +11,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/mattn/go-sqlite3"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
@@ -61,7 +62,7 @@ func TestStation8(t *testing.T) {
 			t.Parallel()
 
 			svc := service.NewTODOService(d)
-			got, err := svc.CreateTODO(context.Background(), tc.Subject, tc.Description)
+			got, err := svc.CreateTODO(auth.ContextInternal(context.Background()), tc.Subject, tc.Description)
 			switch tc.WantError {
 			case nil:
 				if err != nil {
@@ -13,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
+	"github.com/TechBowl-japan/go-stations/auth"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/handler"
 	"github.com/TechBowl-japan/go-stations/handler/router"
@@ -41,7 +42,10 @@ func TestStation9(t *testing.T) {
 	r := router.NewRouter(todoDB)
 	h := handler.NewTODOHandler(service.NewTODOService(todoDB))
 	r.Handle("/todos", h)
-	srv := httptest.NewServer(r)
+	// the stations predate users, so the requests act as the server itself
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.ServeHTTP(w, req.WithContext(auth.ContextInternal(req.Context())))
+	}))
 	defer srv.Close()
 
 	testcases := map[string]struct {
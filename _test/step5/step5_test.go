@@ -58,7 +58,7 @@ func TestStation19(t *testing.T) {
 		},
 		"Authentication is not required(2)": {
 			Path:               "/do-panic",
-			WantHTTPStatusCode: http.StatusOK,
+			WantHTTPStatusCode: http.StatusInternalServerError,
 		},
 		"UserID and Password are correct": {
 			Path:               "/todos",
@@ -0,0 +1,60 @@
+// Package auth carries the identity of the authenticated caller between the
+// HTTP middlewares and the services.
+package auth
+
+import (
+	"context"
+)
+
+type (
+	userKey     struct{}
+	internalKey struct{}
+	recorderKey struct{}
+)
+
+// ContextWithUser returns a copy of parent that carries the authenticated user.
+// The user is also reported to the recorder installed with RecordUser, if any.
+func ContextWithUser(parent context.Context, user string) context.Context {
+	if rec, ok := parent.Value(recorderKey{}).(*string); ok {
+		*rec = user
+	}
+	return context.WithValue(parent, userKey{}, user)
+}
+
+// UserFromContext returns the authenticated user stored in ctx.
+// ok is false for contexts that were not authenticated, e.g. internal calls.
+func UserFromContext(ctx context.Context) (user string, ok bool) {
+	user, ok = ctx.Value(userKey{}).(string)
+	return user, ok && user != ""
+}
+
+// ContextInternal returns a copy of parent marking the calls the server makes
+// on its own behalf, such as delivering webhooks, which act on the data of
+// every user.
+func ContextInternal(parent context.Context) context.Context {
+	return context.WithValue(parent, internalKey{}, true)
+}
+
+// IsInternal reports whether ctx was marked by ContextInternal. A user
+// authenticated further down the chain takes precedence over the mark.
+func IsInternal(ctx context.Context) bool {
+	if _, authenticated := ctx.Value(userKey{}).(string); authenticated {
+		return false
+	}
+	internal, _ := ctx.Value(internalKey{}).(bool)
+	return internal
+}
+
+// RecordUser returns a copy of parent and a function reporting the user that
+// handlers further down authenticated with the returned context. It lets
+// outer middlewares such as the access log see who made the request.
+func RecordUser(parent context.Context) (context.Context, func() string) {
+	if rec, ok := parent.Value(recorderKey{}).(*string); ok {
+		return parent, func() string { return *rec }
+	}
+	rec := new(string)
+	if user, ok := UserFromContext(parent); ok {
+		*rec = user
+	}
+	return context.WithValue(parent, recorderKey{}, rec), func() string { return *rec }
+}
@@ -0,0 +1,34 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+)
+
+func TestIsInternal(t *testing.T) {
+	t.Parallel()
+
+	internal := auth.ContextInternal(context.Background())
+	cases := map[string]struct {
+		ctx  context.Context
+		want bool
+	}{
+		"Unmarked":                  {ctx: context.Background(), want: false},
+		"Marked":                    {ctx: internal, want: true},
+		"User":                      {ctx: auth.ContextWithUser(context.Background(), "alice"), want: false},
+		"User below the mark":       {ctx: auth.ContextWithUser(internal, "alice"), want: false},
+		"Empty user below the mark": {ctx: auth.ContextWithUser(internal, ""), want: false},
+		"Mark below an empty user":  {ctx: auth.ContextInternal(auth.ContextWithUser(context.Background(), "")), want: false},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := auth.IsInternal(tc.ctx); got != tc.want {
+				t.Errorf("IsInternal = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package cache implements an in-memory least recently used cache whose
+// entries expire.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// An LRU holds values up to a total cost, evicting the least recently used
+// ones first, and forgets each value ttl after it was added. It is safe for
+// concurrent use.
+type LRU struct {
+	maxCost int
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	cost  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	cost    int
+	expires time.Time
+}
+
+// NewLRU returns an empty LRU holding values up to maxCost for ttl each.
+func NewLRU(maxCost int, ttl time.Duration) *LRU {
+	return &LRU{
+		maxCost: maxCost,
+		ttl:     ttl,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+// Get returns the value of key, unless it is missing or expired.
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Add sets the value of key, which counts as cost towards the bound. Values
+// costing more than the whole bound are not added.
+func (c *LRU) Add(key string, value interface{}, cost int) {
+	if cost > c.maxCost {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, cost: cost, expires: time.Now().Add(c.ttl)})
+	c.cost += cost
+	for c.cost > c.maxCost {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Purge removes every value.
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	c.cost = 0
+}
+
+// Len returns the number of values held, including expired ones not yet
+// evicted.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.cost -= e.cost
+}
@@ -0,0 +1,63 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/cache"
+)
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+
+	c := cache.NewLRU(3, time.Hour)
+	c.Add("a", 1, 1)
+	c.Add("b", 2, 1)
+	c.Add("c", 3, 1)
+	// a becomes the most recently used
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v, want 1, true", v, ok)
+	}
+	c.Add("d", 4, 2)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%s) found = %v, want %v", key, ok, want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+
+	c.Add("e", 5, 4)
+	if _, ok := c.Get("e"); ok {
+		t.Error("value costing more than the bound was added")
+	}
+
+	c.Add("a", 10, 1)
+	if v, _ := c.Get("a"); v != 10 {
+		t.Errorf("Get(a) = %v after replacing, want 10", v)
+	}
+
+	c.Purge()
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("values remain after Purge")
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	t.Parallel()
+
+	c := cache.NewLRU(10, 50*time.Millisecond)
+	c.Add("a", 1, 1)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("value missing before expiry")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("value found after expiry")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d, want expired value evicted", c.Len())
+	}
+}
@@ -0,0 +1,151 @@
+// Package certs serves TLS certificates that are reloaded when their files
+// change, and loads the CAs used to verify client certificates.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+// A Reloader holds the key pair loaded from a certificate and key file.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	// Heartbeat, when set before Watch starts, is beaten on every check.
+	Heartbeat *health.Heartbeat
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the key pair at certFile and keyFile.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the key pair again. The previous pair is kept on failure.
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate serves as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the key pair whenever either file changes, checking every
+// interval until ctx is done. Certificates renewed in place, e.g. by an ACME
+// client, are picked up without restarting.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.Heartbeat.Beat()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := r.latestModTime()
+		if err != nil {
+			logger.Default().Error("failed to stat certificate", "cert", r.certFile, "err", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := !modTime.Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			// the key may be written after the certificate; retry next tick
+			logger.Default().Error("failed to reload certificate, keeping previous one", "cert", r.certFile, "err", err)
+			continue
+		}
+		logger.Default().Info("certificate reloaded", "cert", r.certFile)
+	}
+}
+
+// LoadCertPool reads the PEM encoded certificates at path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("certs: no certificate found in " + path)
+	}
+	return pool, nil
+}
+
+// ClientAuth values of Config.
+const (
+	ClientAuthNone     = ""
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// ServerConfig returns the TLS configuration of a server presenting the
+// certificate of r with HTTP/2 enabled. With clientCAs, client certificates
+// signed by them are verified, and required when clientAuth is
+// ClientAuthRequire.
+func ServerConfig(r *Reloader, clientCAs *x509.CertPool, clientAuth string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if clientAuth == ClientAuthRequire {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,218 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/certs"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+// issue returns a certificate for tmpl signed by parent, or self-signed when
+// parent is nil, with its key.
+func issue(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func template(serial int64, cn string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func newCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	tmpl := template(1, "test CA")
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign
+	return issue(t, tmpl, nil, nil)
+}
+
+func newServerCert(t *testing.T, serial int64, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	tmpl := template(serial, "localhost")
+	tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	return issue(t, tmpl, ca, caKey)
+}
+
+// writePair writes cert and key as PEM files in dir.
+func writePair(t *testing.T, dir string, cert *x509.Certificate, key *ecdsa.PrivateKey) (certFile, keyFile string) {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func serial(t *testing.T, r *certs.Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca, caKey := newCA(t)
+	cert, key := newServerCert(t, 2, ca, caKey)
+	certFile, keyFile := writePair(t, dir, cert, key)
+	r, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(t, r); got != 2 {
+		t.Fatalf("serial = %d, want 2", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	cert, key = newServerCert(t, 3, ca, caKey)
+	writePair(t, dir, cert, key)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for serial(t, r) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca, caKey := newCA(t)
+	cert, key := newServerCert(t, 2, ca, caKey)
+	r, err := certs.NewReloader(writePair(t, dir, cert, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	clientTmpl := template(4, "alice")
+	clientTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	clientCert, clientKey := issue(t, clientTmpl, ca, caKey)
+
+	certUser, err := middleware.ClientCertUserByField("cn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	h := middleware.NewClientCertAuth(certUser, deny)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.UserFromContext(r.Context())
+		w.Write([]byte(user))
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h, TLSConfig: certs.ServerConfig(r, pool, certs.ClientAuthOptional)}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	tests := map[string]struct {
+		certs      []tls.Certificate
+		wantStatus int
+		wantBody   string
+	}{
+		"with client certificate": {
+			certs:      []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+			wantStatus: http.StatusOK,
+			wantBody:   "alice",
+		},
+		"without client certificate": {
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for name, tc := range tests {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: tc.certs},
+				ForceAttemptHTTP2: true,
+			}}
+			res, err := client.Get("https://" + ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ProtoMajor != 2 {
+				t.Errorf("protocol = %s, want HTTP/2", res.Proto)
+			}
+			if res.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", res.StatusCode, tc.wantStatus)
+			}
+			if string(body) != tc.wantBody {
+				t.Errorf("body = %q, want %q", body, tc.wantBody)
+			}
+		})
+	}
+}
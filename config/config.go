@@ -0,0 +1,427 @@
+// Package config loads the server configuration from defaults, an optional
+// YAML or TOML file, environment variables and command-line flags, each
+// layer overriding the previous one.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/listener"
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+// A Config is the effective configuration of the server. The env tag names
+// the environment variable of a field, and fields tagged secret are redacted
+// when printed. Flags are named after the dotted yaml keys, such as
+// -server.addr.
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server"`
+	TLS         TLSConfig         `yaml:"tls" toml:"tls"`
+	DB          DBConfig          `yaml:"db" toml:"db"`
+	Timeout     TimeoutConfig     `yaml:"timeout" toml:"timeout"`
+	Timezone    string            `yaml:"timezone" toml:"timezone" env:"TIMEZONE"`
+	Log         LogConfig         `yaml:"log" toml:"log"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	CORS        CORSConfig        `yaml:"cors" toml:"cors"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit" toml:"rate_limit"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency"`
+	Cache       CacheConfig       `yaml:"cache" toml:"cache"`
+	Events      EventsConfig      `yaml:"events" toml:"events"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks" toml:"webhooks"`
+	Trace       TraceConfig       `yaml:"trace" toml:"trace"`
+	Sentry      SentryConfig      `yaml:"sentry" toml:"sentry"`
+	Readiness   ReadinessConfig   `yaml:"readiness" toml:"readiness"`
+	Shutdown    ShutdownConfig    `yaml:"shutdown" toml:"shutdown"`
+}
+
+// A ServerConfig configures the HTTP server. Listen holds the addresses
+// accepted by listener.ParseAddr; when empty, the server listens on Addr.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" toml:"addr" env:"PORT"`
+	Listen            []string      `yaml:"listen" toml:"listen" env:"SERVER_LISTEN"`
+	UnixSocketMode    string        `yaml:"unix_socket_mode" toml:"unix_socket_mode" env:"SERVER_UNIX_SOCKET_MODE"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" toml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES"`
+	MaxBodyBytes      int64         `yaml:"max_body_bytes" toml:"max_body_bytes" env:"SERVER_MAX_BODY_BYTES"`
+	Compress          bool          `yaml:"compress" toml:"compress" env:"SERVER_COMPRESS"`
+	CompressMinSize   int           `yaml:"compress_min_size" toml:"compress_min_size" env:"SERVER_COMPRESS_MIN_SIZE"`
+	TrustedProxies    []string      `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+// ListenAddrs returns the addresses the server listens on.
+func (c *ServerConfig) ListenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{c.Addr}
+}
+
+// SocketMode returns the permissions of Unix sockets, written in octal.
+func (c *ServerConfig) SocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode&^0o777 != 0 {
+		return 0, fmt.Errorf("invalid permissions %q", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// A TLSConfig configures HTTPS. TLS is enabled when CertFile is set, and
+// client certificates are verified when ClientCAFile is set. RedirectAddr,
+// when set, listens for plain HTTP and redirects to the port of the first
+// TCP socket served over HTTPS.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	ClientCAFile   string        `yaml:"client_ca_file" toml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	ClientAuth     string        `yaml:"client_auth" toml:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientUser     string        `yaml:"client_user" toml:"client_user" env:"TLS_CLIENT_USER"`
+	RedirectAddr   string        `yaml:"redirect_addr" toml:"redirect_addr" env:"TLS_REDIRECT_ADDR"`
+}
+
+// A TimeoutConfig bounds the time the handlers of each route group may take,
+// including their queries. Zero disables the timeout.
+type TimeoutConfig struct {
+	TODOs time.Duration `yaml:"todos" toml:"todos" env:"HANDLER_TIMEOUT_TODOS"`
+	Lists time.Duration `yaml:"lists" toml:"lists" env:"HANDLER_TIMEOUT_LISTS"`
+	Admin time.Duration `yaml:"admin" toml:"admin" env:"HANDLER_TIMEOUT_ADMIN"`
+}
+
+// A DBConfig configures the SQLite database.
+type DBConfig struct {
+	Path string `yaml:"path" toml:"path" env:"DB_PATH"`
+}
+
+// A LogConfig configures the application and access logs.
+type LogConfig struct {
+	Level           string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	AccessLogFormat string `yaml:"access_log_format" toml:"access_log_format" env:"ACCESS_LOG_FORMAT"`
+	AccessLogFile   string `yaml:"access_log_file" toml:"access_log_file" env:"ACCESS_LOG_FILE"`
+}
+
+// An AuthConfig configures Basic authentication and its lockout. Only
+// AdminUsers may use the admin endpoints, nobody while it is empty. Failed
+// logins are delayed by BaseDelay, doubling up to MaxDelay, and forgotten
+// FailureWindow after the last one.
+type AuthConfig struct {
+	UserID           string        `yaml:"user_id" toml:"user_id" env:"BASIC_AUTH_USER_ID"`
+	Password         string        `yaml:"password" toml:"password" env:"BASIC_AUTH_PASSWORD" secret:"true"`
+	HtpasswdFile     string        `yaml:"htpasswd_file" toml:"htpasswd_file" env:"HTPASSWD_FILE"`
+	AdminUsers       []string      `yaml:"admin_users" toml:"admin_users" env:"ADMIN_USERS"`
+	MaxFailures      int           `yaml:"max_failures" toml:"max_failures" env:"LOGIN_MAX_FAILURES"`
+	MaxFailuresPerIP int           `yaml:"max_failures_per_ip" toml:"max_failures_per_ip" env:"LOGIN_MAX_FAILURES_PER_IP"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration" toml:"lockout_duration" env:"LOGIN_LOCKOUT_DURATION"`
+	BaseDelay        time.Duration `yaml:"base_delay" toml:"base_delay" env:"LOGIN_BASE_DELAY"`
+	MaxDelay         time.Duration `yaml:"max_delay" toml:"max_delay" env:"LOGIN_MAX_DELAY"`
+	FailureWindow    time.Duration `yaml:"failure_window" toml:"failure_window" env:"LOGIN_FAILURE_WINDOW"`
+}
+
+// A CORSConfig configures cross-origin requests from browsers. CORS is
+// disabled while AllowedOrigins is empty, and credentials cannot be allowed
+// to the origin "*".
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+// Middleware returns the middleware.CORSConfig configured by c.
+func (c *CORSConfig) Middleware() middleware.CORSConfig {
+	return middleware.CORSConfig{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+// A RateLimitConfig holds the rate limits per route and user, and IP, the
+// limit per client IP applied before authentication, written as
+// "<count>/<unit>[:<burst>]".
+type RateLimitConfig struct {
+	TODOs string `yaml:"todos" toml:"todos" env:"RATE_LIMIT_TODOS"`
+	Lists string `yaml:"lists" toml:"lists" env:"RATE_LIMIT_LISTS"`
+	IP    string `yaml:"ip" toml:"ip" env:"RATE_LIMIT_IP"`
+}
+
+// An IdempotencyConfig configures Idempotency-Key handling.
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"IDEMPOTENCY_TTL"`
+}
+
+// A CacheConfig configures the cache of TODO reads. Size is the number of
+// TODOs held across all reads.
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled" toml:"enabled" env:"CACHE_ENABLED"`
+	Size    int           `yaml:"size" toml:"size" env:"CACHE_SIZE"`
+	TTL     time.Duration `yaml:"ttl" toml:"ttl" env:"CACHE_TTL"`
+}
+
+// An EventsConfig configures the stream of TODO changes. PollInterval is
+// how often new changes are looked for, Heartbeat how often idle streams
+// are sent a comment, and Retention how long changes are kept for streams
+// to resume from.
+type EventsConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"EVENTS_POLL_INTERVAL"`
+	Heartbeat    time.Duration `yaml:"heartbeat" toml:"heartbeat" env:"EVENTS_HEARTBEAT"`
+	Retention    time.Duration `yaml:"retention" toml:"retention" env:"EVENTS_RETENTION"`
+}
+
+// A WebhooksConfig configures the deliveries of webhooks. An attempt times
+// out after Timeout and is retried after Backoff, doubled on every failure
+// up to MaxBackoff, until MaxAttempts were made. Webhooks are disabled after
+// DisableAfter failed attempts in a row, and finished deliveries are kept
+// for Retention. Receivers on loopback, private and link-local addresses are
+// refused unless AllowPrivateNetworks is set.
+type WebhooksConfig struct {
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"WEBHOOKS_TIMEOUT"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS"`
+	Backoff      time.Duration `yaml:"backoff" toml:"backoff" env:"WEBHOOKS_BACKOFF"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" toml:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF"`
+	DisableAfter int           `yaml:"disable_after" toml:"disable_after" env:"WEBHOOKS_DISABLE_AFTER"`
+	Concurrency  int           `yaml:"concurrency" toml:"concurrency" env:"WEBHOOKS_CONCURRENCY"`
+	Retention    time.Duration `yaml:"retention" toml:"retention" env:"WEBHOOKS_RETENTION"`
+
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" toml:"allow_private_networks" env:"WEBHOOKS_ALLOW_PRIVATE_NETWORKS"`
+}
+
+// A TraceConfig configures the span exporter. Exporter is "" to only
+// propagate trace context, "json" or "otlp".
+type TraceConfig struct {
+	Exporter     string `yaml:"exporter" toml:"exporter" env:"TRACE_EXPORTER"`
+	File         string `yaml:"file" toml:"file" env:"TRACE_FILE"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName  string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+// A SentryConfig configures the panic reporter.
+type SentryConfig struct {
+	DSN         string `yaml:"dsn" toml:"dsn" env:"SENTRY_DSN" secret:"true"`
+	Environment string `yaml:"environment" toml:"environment" env:"SENTRY_ENVIRONMENT"`
+}
+
+// A ReadinessConfig configures the readiness checks.
+type ReadinessConfig struct {
+	MinFreeDisk uint64 `yaml:"min_free_disk" toml:"min_free_disk" env:"READYZ_MIN_FREE_DISK"`
+}
+
+// A ShutdownConfig configures graceful shutdown, and restarts on SIGUSR2.
+// RestartTimeout bounds the wait for the new process to become ready.
+type ShutdownConfig struct {
+	Delay          time.Duration `yaml:"delay" toml:"delay" env:"SHUTDOWN_DELAY"`
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout" env:"SHUTDOWN_TIMEOUT"`
+	RestartTimeout time.Duration `yaml:"restart_timeout" toml:"restart_timeout" env:"SHUTDOWN_RESTART_TIMEOUT"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	lockout := middleware.DefaultLockoutConfig()
+	cors := middleware.DefaultCORSConfig()
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			UnixSocketMode:    "0660",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      1 << 20,
+			Compress:          true,
+			CompressMinSize:   middleware.DefaultCompressMinSize,
+		},
+		TLS: TLSConfig{
+			ReloadInterval: time.Minute,
+			ClientUser:     "cn",
+		},
+		Timeout: TimeoutConfig{
+			TODOs: 10 * time.Second,
+			Lists: 10 * time.Second,
+			Admin: 10 * time.Second,
+		},
+		DB:       DBConfig{Path: ".sqlite3/todo.db"},
+		Timezone: "Asia/Tokyo",
+		Log: LogConfig{
+			Level:           "info",
+			AccessLogFormat: "json",
+		},
+		Auth: AuthConfig{
+			MaxFailures:      lockout.UserMaxFailures,
+			MaxFailuresPerIP: lockout.IPMaxFailures,
+			LockoutDuration:  lockout.LockoutDuration,
+			BaseDelay:        lockout.BaseDelay,
+			MaxDelay:         lockout.MaxDelay,
+			FailureWindow:    lockout.Window,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: cors.AllowedMethods,
+			AllowedHeaders: cors.AllowedHeaders,
+			ExposedHeaders: cors.ExposedHeaders,
+			MaxAge:         cors.MaxAge,
+		},
+		RateLimit: RateLimitConfig{
+			TODOs: "10/s:20",
+			Lists: "5/s:10",
+			IP:    "50/s:100",
+		},
+		Idempotency: IdempotencyConfig{TTL: 24 * time.Hour},
+		Cache: CacheConfig{
+			Size: 10000,
+			TTL:  5 * time.Second,
+		},
+		Events: EventsConfig{
+			PollInterval: 250 * time.Millisecond,
+			Heartbeat:    15 * time.Second,
+			Retention:    24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:      10 * time.Second,
+			MaxAttempts:  10,
+			Backoff:      10 * time.Second,
+			MaxBackoff:   time.Hour,
+			DisableAfter: 20,
+			Concurrency:  4,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Trace: TraceConfig{
+			OTLPEndpoint: "http://localhost:4318",
+			ServiceName:  "go-stations",
+		},
+		Readiness: ReadinessConfig{MinFreeDisk: 64 << 20},
+		Shutdown: ShutdownConfig{
+			Timeout:        10 * time.Second,
+			RestartTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// Validate reports every invalid value of c at once.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "" || len(c.Server.Listen) > 0, "server.addr or server.listen must be set")
+	for _, addr := range c.Server.Listen {
+		if _, err := listener.ParseAddr(addr); err != nil {
+			check(false, "server.listen: %v", err)
+		}
+	}
+	if _, err := c.Server.SocketMode(); err != nil {
+		check(false, "server.unix_socket_mode: %v", err)
+	}
+	for name, d := range map[string]time.Duration{
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.read_header_timeout": c.Server.ReadHeaderTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+		"auth.lockout_duration":      c.Auth.LockoutDuration,
+		"auth.base_delay":            c.Auth.BaseDelay,
+		"auth.max_delay":             c.Auth.MaxDelay,
+		"auth.failure_window":        c.Auth.FailureWindow,
+		"idempotency.ttl":            c.Idempotency.TTL,
+		"shutdown.delay":             c.Shutdown.Delay,
+		"shutdown.timeout":           c.Shutdown.Timeout,
+		"timeout.todos":              c.Timeout.TODOs,
+		"timeout.lists":              c.Timeout.Lists,
+		"timeout.admin":              c.Timeout.Admin,
+		"cors.max_age":               c.CORS.MaxAge,
+	} {
+		check(d >= 0, "%s must not be negative", name)
+	}
+	if _, err := middleware.NewRealIP(c.Server.TrustedProxies); err != nil {
+		check(false, "server.trusted_proxies: %v", err)
+	}
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes must be positive")
+	check(c.Server.MaxBodyBytes > 0, "server.max_body_bytes must be positive")
+	check(c.Server.CompressMinSize >= 0, "server.compress_min_size must not be negative")
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		check(c.TLS.CertFile != "" && c.TLS.KeyFile != "", "tls.cert_file and tls.key_file must be set together")
+		check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+		if c.TLS.RedirectAddr != "" {
+			if _, err := listener.ParseAddr(c.TLS.RedirectAddr); err != nil {
+				check(false, "tls.redirect_addr: %v", err)
+			}
+			for _, addr := range c.Server.ListenAddrs() {
+				check(addr != c.TLS.RedirectAddr, "tls.redirect_addr must differ from the server addresses")
+			}
+		}
+	} else {
+		check(c.TLS.ClientCAFile == "", "tls.client_ca_file requires tls.cert_file")
+		check(c.TLS.RedirectAddr == "", "tls.redirect_addr requires tls.cert_file")
+	}
+	check(c.TLS.ClientAuth == "" || c.TLS.ClientAuth == "optional" || c.TLS.ClientAuth == "require",
+		"tls.client_auth must be empty, optional or require, got %q", c.TLS.ClientAuth)
+	check(c.TLS.ClientAuth == "" || c.TLS.ClientCAFile != "", "tls.client_auth requires tls.client_ca_file")
+	if _, err := middleware.ClientCertUserByField(c.TLS.ClientUser); err != nil {
+		check(false, "tls.client_user: %v", err)
+	}
+	check(c.Shutdown.RestartTimeout > 0, "shutdown.restart_timeout must be positive")
+	check(c.Events.PollInterval > 0, "events.poll_interval must be positive")
+	check(c.Events.Heartbeat > 0, "events.heartbeat must be positive")
+	check(c.Events.Retention > 0, "events.retention must be positive")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(c.Webhooks.Backoff > 0, "webhooks.backoff must be positive")
+	check(c.Auth.MaxDelay >= c.Auth.BaseDelay, "auth.max_delay must not be less than auth.base_delay")
+	check(c.Webhooks.MaxBackoff >= c.Webhooks.Backoff, "webhooks.max_backoff must not be less than webhooks.backoff")
+	check(c.Webhooks.DisableAfter > 0, "webhooks.disable_after must be positive")
+	check(c.Webhooks.Concurrency > 0, "webhooks.concurrency must be positive")
+	check(c.Webhooks.Retention > 0, "webhooks.retention must be positive")
+	if c.Cache.Enabled {
+		check(c.Cache.Size > 0, "cache.size must be positive")
+		check(c.Cache.TTL > 0, "cache.ttl must be positive")
+	}
+	check(c.DB.Path != "", "db.path must not be empty")
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		check(false, "timezone: %v", err)
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		check(false, "log.level: %v", err)
+	}
+	check(c.Log.AccessLogFormat == "json" || c.Log.AccessLogFormat == "combined",
+		"log.access_log_format must be json or combined, got %q", c.Log.AccessLogFormat)
+	check(c.Auth.HtpasswdFile != "" || (c.Auth.UserID != "" && c.Auth.Password != ""),
+		"auth.user_id and auth.password, or auth.htpasswd_file, must be set")
+	check(c.Auth.MaxFailures > 0, "auth.max_failures must be positive")
+	check(c.Auth.MaxFailuresPerIP > 0, "auth.max_failures_per_ip must be positive")
+	if _, err := middleware.NewCORS(c.CORS.Middleware()); err != nil {
+		check(false, "cors: %v", err)
+	}
+	for name, spec := range map[string]string{"rate_limit.todos": c.RateLimit.TODOs, "rate_limit.lists": c.RateLimit.Lists, "rate_limit.ip": c.RateLimit.IP} {
+		if _, err := middleware.ParseRateLimit(spec); err != nil {
+			check(false, "%s: %v", name, err)
+		}
+	}
+	switch c.Trace.Exporter {
+	case "", "json":
+	case "otlp":
+		check(c.Trace.OTLPEndpoint != "", "trace.otlp_endpoint must be set for the otlp exporter")
+	default:
+		check(false, "trace.exporter must be empty, json or otlp, got %q", c.Trace.Exporter)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return errors.New("config: " + strings.Join(errs, "; "))
+}
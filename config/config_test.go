@@ -0,0 +1,147 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/config"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(yamlPath, []byte(`
+server:
+  addr: ":9000"
+  read_timeout: 5s
+  trusted_proxies: [10.0.0.0/8]
+db:
+  path: from-file.db
+timezone: UTC
+auth:
+  user_id: alice
+  password: from-file
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tomlPath := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(tomlPath, []byte(`
+timezone = "UTC"
+[server]
+addr = ":9100"
+write_timeout = "3s"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	typoPath := filepath.Join(dir, "typo.yaml")
+	if err := os.WriteFile(typoPath, []byte("server:\n  adr: \":1\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := func(m map[string]string) func(string) string {
+		return func(key string) string { return m[key] }
+	}
+	// withCredentials adds the credentials the server refuses to start without
+	withCredentials := func(m map[string]string) map[string]string {
+		with := map[string]string{"BASIC_AUTH_USER_ID": "u", "BASIC_AUTH_PASSWORD": "p"}
+		for k, v := range m {
+			with[k] = v
+		}
+		return with
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		c, err := config.Load(nil, env(withCredentials(nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Server.Addr != ":8080" || c.DB.Path != ".sqlite3/todo.db" || c.Timezone != "Asia/Tokyo" {
+			t.Errorf("unexpected defaults %+v", c)
+		}
+	})
+
+	t.Run("Layers", func(t *testing.T) {
+		c, err := config.Load(
+			[]string{"-config", yamlPath, "-db.path", "from-flag.db", "-auth.admin_users", "alice, bob"},
+			env(map[string]string{"DB_PATH": "from-env.db", "PORT": ":9001", "SERVER_IDLE_TIMEOUT": "1m"}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Server.Addr != ":9001" {
+			t.Errorf("env did not override the file, addr = %s", c.Server.Addr)
+		}
+		if c.DB.Path != "from-flag.db" {
+			t.Errorf("flag did not override env, db.path = %s", c.DB.Path)
+		}
+		if c.Server.ReadTimeout != 5*time.Second || c.Server.IdleTimeout != time.Minute || c.Timezone != "UTC" {
+			t.Errorf("unexpected server config %+v, timezone %s", c.Server, c.Timezone)
+		}
+		if len(c.Auth.AdminUsers) != 2 || c.Auth.AdminUsers[1] != "bob" {
+			t.Errorf("admin users = %q", c.Auth.AdminUsers)
+		}
+
+		var buf bytes.Buffer
+		if err := c.Print(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), "from-file") || !strings.Contains(buf.String(), "password: "+config.Redacted) {
+			t.Errorf("secret not redacted:\n%s", buf.String())
+		}
+		if c.Auth.Password != "from-file" {
+			t.Error("printing redacted the config itself")
+		}
+	})
+
+	t.Run("TOML from env", func(t *testing.T) {
+		c, err := config.Load(nil, env(withCredentials(map[string]string{config.FileEnv: tomlPath})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Server.Addr != ":9100" || c.Server.WriteTimeout != 3*time.Second {
+			t.Errorf("unexpected server config %+v", c.Server)
+		}
+	})
+
+	t.Run("Htpasswd only", func(t *testing.T) {
+		if _, err := config.Load(nil, env(map[string]string{"HTPASSWD_FILE": "users.htpasswd"})); err != nil {
+			t.Error(err)
+		}
+	})
+
+	errCases := map[string]struct {
+		args []string
+		env  map[string]string
+	}{
+		"Unknown key":                 {args: []string{"-config", typoPath}},
+		"Bad duration":                {env: map[string]string{"SHUTDOWN_TIMEOUT": "soon"}},
+		"Bad flag value":              {args: []string{"-readiness.min_free_disk", "-1"}},
+		"Unknown timezone":            {args: []string{"-timezone", "Mars/Olympus"}},
+		"Bad rate limit":              {env: map[string]string{"RATE_LIMIT_TODOS": "fast"}},
+		"Bad IP rate limit":           {env: map[string]string{"RATE_LIMIT_IP": "0/s"}},
+		"Any origin with credentials": {env: map[string]string{"CORS_ALLOWED_ORIGINS": "*", "CORS_ALLOW_CREDENTIALS": "true"}},
+		"Bad exporter":                {args: []string{"-trace.exporter", "zipkin"}},
+		"Bad listen addr":             {env: map[string]string{"SERVER_LISTEN": "unix://"}},
+		"Bad socket mode":             {args: []string{"-server.unix_socket_mode", "rw"}},
+		"Empty cache":                 {env: map[string]string{"CACHE_ENABLED": "true", "CACHE_SIZE": "0"}},
+		"Short backoff cap":           {env: map[string]string{"WEBHOOKS_BACKOFF": "1m", "WEBHOOKS_MAX_BACKOFF": "1s"}},
+		"Short login delay cap":       {env: map[string]string{"LOGIN_BASE_DELAY": "1m", "LOGIN_MAX_DELAY": "1s"}},
+		"Negative failure window":     {args: []string{"-auth.failure_window", "-1s"}},
+		"No credentials":              {env: map[string]string{"BASIC_AUTH_USER_ID": "", "BASIC_AUTH_PASSWORD": ""}},
+		"No password":                 {env: map[string]string{"BASIC_AUTH_PASSWORD": ""}},
+	}
+	for name, tc := range errCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if _, err := config.Load(tc.args, env(withCredentials(tc.env))); err == nil {
+				t.Error("invalid config was accepted")
+			}
+		})
+	}
+}
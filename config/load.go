@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable holding the config file path. The
+// -config flag takes precedence over it.
+const FileEnv = "CONFIG_FILE"
+
+// Load returns the configuration made of the defaults, the file named by the
+// -config flag or FileEnv, the environment looked up with getenv and the
+// flags in args, in increasing precedence, after validating it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("go-stations", flag.ContinueOnError)
+	file := fs.String("config", getenv(FileEnv), "path to a YAML or TOML config file")
+	values := map[string]*flagValue{}
+	walk(reflect.ValueOf(c).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		fv := &flagValue{field: v}
+		values[key] = fv
+		usage := "sets " + key
+		if env := f.Tag.Get("env"); env != "" {
+			usage += ", or $" + env
+		}
+		fs.Var(fv, key, usage)
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("config: unexpected arguments %q", fs.Args())
+	}
+
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	walk(reflect.ValueOf(c).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		env := f.Tag.Get("env")
+		if env == "" || err != nil {
+			return
+		}
+		if s := getenv(env); s != "" {
+			if setErr := set(v, s); setErr != nil {
+				err = fmt.Errorf("config: $%s: %w", env, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		fv, ok := values[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := set(fv.field, fv.raw); setErr != nil {
+			err = fmt.Errorf("config: -%s: %w", f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile overrides c with the file at path, rejecting unknown keys so that
+// typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(body))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(body), c)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config: %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config: %s: unsupported file type %q", path, ext)
+	}
+	return nil
+}
+
+// walk calls fn with every leaf field under v and its dotted yaml key.
+func walk(v reflect.Value, prefix string, fn func(f reflect.StructField, v reflect.Value, key string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key+".", fn)
+			continue
+		}
+		fn(f, v.Field(i), key)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into v. Lists are comma separated.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// A flagValue validates a flag when parsed and keeps it until the lower
+// layers have been applied to field.
+type flagValue struct {
+	field reflect.Value
+	raw   string
+}
+
+func (f *flagValue) String() string {
+	if f == nil || !f.field.IsValid() {
+		return ""
+	}
+	return format(f.field)
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := set(reflect.New(f.field.Type()).Elem(), s); err != nil {
+		return err
+	}
+	f.raw = s
+	return nil
+}
+
+// format renders v the way set parses it.
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
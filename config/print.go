@@ -0,0 +1,32 @@
+package config
+
+import (
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted is printed in place of the value of a secret.
+const Redacted = "REDACTED"
+
+// Redact returns a copy of c whose secrets are replaced by Redacted.
+func (c *Config) Redact() *Config {
+	r := *c
+	walk(reflect.ValueOf(&r).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		if f.Tag.Get("secret") == "true" && v.Kind() == reflect.String && v.String() != "" {
+			v.SetString(Redacted)
+		}
+	})
+	return &r
+}
+
+// Print writes c as YAML with its secrets redacted, in the format Load reads.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redact()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
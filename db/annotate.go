@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the sqlite3 driver that prefixes every statement run with a
+// request context by a comment naming the request ID, so that slow queries
+// can be traced back to the request.
+const driverName = "sqlite3_annotated"
+
+func init() {
+	sql.Register(driverName, &annotatingDriver{Driver: &sqlite3.SQLiteDriver{}})
+}
+
+// annotate prefixes query with the request ID carried by ctx.
+func annotate(ctx context.Context, query string) string {
+	id, ok := requestid.FromContext(ctx)
+	if !ok {
+		return query
+	}
+	return "/* request_id=" + strings.ReplaceAll(id, "*/", "") + " */ " + query
+}
+
+type annotatingDriver struct {
+	driver.Driver
+}
+
+func (d *annotatingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &annotatingConn{Conn: conn}, nil
+}
+
+// annotatingConn forwards to the sqlite3 connection after annotating queries.
+type annotatingConn struct {
+	driver.Conn
+}
+
+func (c *annotatingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, annotate(ctx, query))
+	}
+	return c.Conn.Prepare(annotate(ctx, query))
+}
+
+func (c *annotatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, annotate(ctx, query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *annotatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, annotate(ctx, query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *annotatingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *annotatingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+)
+
+// recordingConn records the queries prepared on it, without the context
+// aware methods when legacy.
+type recordingConn struct {
+	queries []string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.queries = append(c.queries, query)
+	return nil, nil
+}
+
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return nil, nil }
+
+type recordingContextConn struct {
+	recordingConn
+}
+
+func (c *recordingContextConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Prepare(query)
+}
+
+func (c *recordingContextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	return nil, nil
+}
+
+func (c *recordingContextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	return nil, nil
+}
+
+func TestAnnotatingConn(t *testing.T) {
+	t.Parallel()
+
+	const query = `SELECT 1`
+	cases := map[string]struct {
+		ctx  context.Context
+		want string
+	}{
+		"Request ID":  {ctx: requestid.NewContext(context.Background(), "abc-123"), want: "/* request_id=abc-123 */ SELECT 1"},
+		"Comment end": {ctx: requestid.NewContext(context.Background(), "a*/b"), want: "/* request_id=ab */ SELECT 1"},
+		"No request":  {ctx: context.Background(), want: query},
+		"Empty ID":    {ctx: requestid.NewContext(context.Background(), ""), want: query},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			modern := &recordingContextConn{}
+			c := &annotatingConn{Conn: modern}
+			c.PrepareContext(tc.ctx, query)
+			c.ExecContext(tc.ctx, query, nil)
+			c.QueryContext(tc.ctx, query, nil)
+			if len(modern.queries) != 3 {
+				t.Fatalf("ran %d queries, want 3", len(modern.queries))
+			}
+			for i, got := range modern.queries {
+				if got != tc.want {
+					t.Errorf("query %d = %q, want %q", i, got, tc.want)
+				}
+			}
+
+			// drivers without context support prepare every statement
+			legacy := &recordingConn{}
+			c = &annotatingConn{Conn: legacy}
+			c.PrepareContext(tc.ctx, query)
+			if _, err := c.ExecContext(tc.ctx, query, nil); err != driver.ErrSkip {
+				t.Errorf("ExecContext err = %v, want ErrSkip", err)
+			}
+			if len(legacy.queries) != 1 || legacy.queries[0] != tc.want {
+				t.Errorf("prepared %q, want %q", legacy.queries, tc.want)
+			}
+		})
+	}
+}
@@ -12,7 +12,7 @@ var schema string
 
 // NewDB returns go-sqlite3 driver based *sql.DB.
 func NewDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := sql.Open(driverName, path)
 	if err != nil {
 		return nil, err
 	}
@@ -21,5 +21,9 @@ func NewDB(path string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
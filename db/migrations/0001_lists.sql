@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS lists (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name        TEXT     NOT NULL,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(name <> '')
+);
+
+CREATE TRIGGER IF NOT EXISTS trigger_lists_updated_at AFTER UPDATE ON lists
+BEGIN
+  UPDATE lists SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+
+CREATE TABLE IF NOT EXISTS list_members (
+  list_id     INTEGER  NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+  user_id     TEXT     NOT NULL,
+  permission  TEXT     NOT NULL,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  PRIMARY KEY(list_id, user_id),
+  CHECK(permission IN ('viewer', 'editor', 'owner'))
+);
+
+CREATE INDEX IF NOT EXISTS index_list_members_user_id ON list_members(user_id);
+
+CREATE TABLE IF NOT EXISTS list_invites (
+  token       TEXT     NOT NULL PRIMARY KEY,
+  list_id     INTEGER  NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+  permission  TEXT     NOT NULL,
+  created_by  TEXT     NOT NULL,
+  expires_at  DATETIME NOT NULL,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(permission IN ('viewer', 'editor'))
+);
+
+ALTER TABLE todos ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN list_id INTEGER REFERENCES lists(id);
+
+CREATE INDEX IF NOT EXISTS index_todos_list_id ON todos(list_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  scope         TEXT     NOT NULL,
+  key           TEXT     NOT NULL,
+  request_hash  TEXT     NOT NULL,
+  status        INTEGER,
+  header        TEXT     NOT NULL DEFAULT '{}',
+  body          BLOB,
+  expires_at    DATETIME NOT NULL,
+  created_at    DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  PRIMARY KEY(scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS index_idempotency_keys_expires_at ON idempotency_keys(expires_at);
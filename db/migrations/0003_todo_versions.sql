@@ -0,0 +1,19 @@
+ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+
+DROP TRIGGER IF EXISTS trigger_todos_updated_at;
+
+CREATE TRIGGER trigger_todos_updated_at AFTER UPDATE ON todos
+BEGIN
+  UPDATE todos SET updated_at = DATETIME('now'), version = OLD.version + 1 WHERE id == NEW.id;
+END;
+
+CREATE TABLE IF NOT EXISTS todo_deletions (
+  id          INTEGER  NOT NULL PRIMARY KEY,
+  deleted_at  DATETIME NOT NULL,
+  CHECK(id = 1)
+);
+
+CREATE TRIGGER IF NOT EXISTS trigger_todos_deleted AFTER DELETE ON todos
+BEGIN
+  INSERT OR REPLACE INTO todo_deletions(id, deleted_at) VALUES(1, DATETIME('now'));
+END;
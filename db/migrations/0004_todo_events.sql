@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS todo_events (
+  id           INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  type         TEXT     NOT NULL,
+  todo_id      INTEGER  NOT NULL,
+  subject      TEXT     NOT NULL,
+  description  TEXT     NOT NULL,
+  owner        TEXT     NOT NULL,
+  list_id      INTEGER,
+  todo_created DATETIME NOT NULL,
+  todo_updated DATETIME NOT NULL,
+  created_at   DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(type IN ('created', 'updated', 'deleted'))
+);
+
+CREATE INDEX IF NOT EXISTS index_todo_events_created_at ON todo_events(created_at);
+
+CREATE TRIGGER IF NOT EXISTS trigger_todos_event_created AFTER INSERT ON todos
+BEGIN
+  INSERT INTO todo_events(type, todo_id, subject, description, owner, list_id, todo_created, todo_updated)
+  VALUES('created', NEW.id, NEW.subject, NEW.description, NEW.owner, NEW.list_id, NEW.created_at, NEW.updated_at);
+END;
+
+-- trigger_todos_updated_at bumps the version once the row is updated, so
+-- reacting to the bump records the final updated_at, once.
+CREATE TRIGGER IF NOT EXISTS trigger_todos_event_updated AFTER UPDATE OF version ON todos
+WHEN NEW.version <> OLD.version
+BEGIN
+  INSERT INTO todo_events(type, todo_id, subject, description, owner, list_id, todo_created, todo_updated)
+  VALUES('updated', NEW.id, NEW.subject, NEW.description, NEW.owner, NEW.list_id, NEW.created_at, NEW.updated_at);
+END;
+
+CREATE TRIGGER IF NOT EXISTS trigger_todos_event_deleted AFTER DELETE ON todos
+BEGIN
+  INSERT INTO todo_events(type, todo_id, subject, description, owner, list_id, todo_created, todo_updated)
+  VALUES('deleted', OLD.id, OLD.subject, OLD.description, OLD.owner, OLD.list_id, OLD.created_at, OLD.updated_at);
+END;
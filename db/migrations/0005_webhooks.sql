@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  owner       TEXT     NOT NULL,
+  url         TEXT     NOT NULL,
+  secret      TEXT     NOT NULL,
+  -- comma separated event types, all of them when empty
+  events      TEXT     NOT NULL DEFAULT '',
+  -- consecutive failed attempts, reset by a success
+  failures    INTEGER  NOT NULL DEFAULT 0,
+  disabled_at DATETIME,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(url <> '' AND secret <> '')
+);
+
+CREATE INDEX IF NOT EXISTS index_webhooks_owner ON webhooks(owner);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id              INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  webhook_id      INTEGER  NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_id        INTEGER  NOT NULL,
+  event_type      TEXT     NOT NULL,
+  payload         TEXT     NOT NULL,
+  status          TEXT     NOT NULL DEFAULT 'pending',
+  attempts        INTEGER  NOT NULL DEFAULT 0,
+  response_status INTEGER  NOT NULL DEFAULT 0,
+  error           TEXT     NOT NULL DEFAULT '',
+  next_attempt_at DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  created_at      DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at      DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(status IN ('pending', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS index_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+CREATE INDEX IF NOT EXISTS index_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+-- the last todo_events row turned into deliveries; the events before the
+-- migration are not delivered
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+  id            INTEGER NOT NULL PRIMARY KEY CHECK(id = 1),
+  last_event_id INTEGER NOT NULL
+);
+
+INSERT OR IGNORE INTO webhook_cursor(id, last_event_id) SELECT 1, COALESCE(MAX(id), 0) FROM todo_events;
@@ -3,10 +3,17 @@ module github.com/TechBowl-japan/go-stations
 go 1.16
 
 require (
-	github.com/google/go-cmp v0.5.8 // indirect
+	github.com/BurntSushi/toml v1.2.1
+	github.com/andybalholm/brotli v1.0.5
+	github.com/google/go-cmp v0.5.8
+	github.com/gorilla/websocket v1.5.0
 	github.com/joho/godotenv v1.4.0
 	github.com/jstemmer/go-junit-report v0.9.1
 	github.com/justinas/alice v1.2.0
 	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/mileusna/useragent v1.0.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	gopkg.in/yaml.v3 v3.0.1
 )
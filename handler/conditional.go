@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// privateCacheControl lets only the client store TODO reads, which depend on
+// the user, and has it revalidate them on every use.
+const privateCacheControl = "private, no-cache"
+
+// todoETag returns the ETag of the TODOs in versions represented as
+// mediaType. It is weak as compressed and uncompressed bodies share it.
+func todoETag(versions []*model.TODOVersion, mediaType string) string {
+	h := sha256.New()
+	h.Write([]byte(mediaType))
+	for _, v := range versions {
+		h.Write([]byte("\n" + strconv.FormatInt(v.ID, 10) + ":" + strconv.FormatInt(v.Version, 10)))
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// lastModified returns the latest of since and the update times in versions.
+func lastModified(versions []*model.TODOVersion, since time.Time) time.Time {
+	modified := since
+	for _, v := range versions {
+		if v.UpdatedAt.After(modified) {
+			modified = v.UpdatedAt
+		}
+	}
+	return modified
+}
+
+// checkNotModified sets the validators of the response and answers 304 when
+// the request's conditions show the client's copy is current. It reports
+// whether it did so.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("Cache-Control", privateCacheControl)
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when it is absent
+// as RFC 9110 has it.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	// Last-Modified has a precision of seconds
+	return !modified.Truncate(time.Second).After(since)
+}
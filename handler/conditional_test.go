@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	versions := []*model.TODOVersion{
+		{ID: 2, Version: 0, UpdatedAt: modified.Add(-time.Hour)},
+		{ID: 1, Version: 3, UpdatedAt: modified},
+	}
+	etag := todoETag(versions, mediaJSON)
+
+	if got := todoETag(versions, mediaCSV); got == etag {
+		t.Error("ETag does not depend on the media type")
+	}
+	updated := []*model.TODOVersion{versions[0], {ID: 1, Version: 4, UpdatedAt: modified}}
+	if got := todoETag(updated, mediaJSON); got == etag {
+		t.Error("ETag does not depend on the versions")
+	}
+	if got := todoETag(versions[1:], mediaJSON); got == etag {
+		t.Error("ETag does not depend on the TODOs")
+	}
+	if got := lastModified(versions, modified.Add(time.Minute)); !got.Equal(modified.Add(time.Minute)) {
+		t.Errorf("lastModified = %v, want the deletion time", got)
+	}
+
+	cases := map[string]struct {
+		header map[string]string
+		want   int
+	}{
+		"Unconditional":           {want: http.StatusOK},
+		"Matching ETag":           {header: map[string]string{"If-None-Match": etag}, want: http.StatusNotModified},
+		"Matching strong ETag":    {header: map[string]string{"If-None-Match": etag[2:]}, want: http.StatusNotModified},
+		"One of ETags":            {header: map[string]string{"If-None-Match": `"other", ` + etag}, want: http.StatusNotModified},
+		"Any ETag":                {header: map[string]string{"If-None-Match": "*"}, want: http.StatusNotModified},
+		"Other ETag":              {header: map[string]string{"If-None-Match": `W/"other"`}, want: http.StatusOK},
+		"Not modified since":      {header: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, want: http.StatusNotModified},
+		"Modified since":          {header: map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, want: http.StatusOK},
+		"Bad date":                {header: map[string]string{"If-Modified-Since": "yesterday"}, want: http.StatusOK},
+		"ETag over modified date": {header: map[string]string{"If-None-Match": `W/"other"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, want: http.StatusOK},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if !checkNotModified(w, r, etag, lastModified(versions, time.Time{})) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q, want %q", got, modified.Format(http.TimeFormat))
+			}
+			if got := w.Header().Get("Cache-Control"); got != privateCacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, privateCacheControl)
+			}
+		})
+	}
+}
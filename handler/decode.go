@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+// errTrailingData is returned for bodies holding more than one JSON value.
+var errTrailingData = errors.New("request body must hold a single JSON value")
+
+// decodeJSON decodes exactly one JSON value from r into v, rejecting fields
+// v does not have.
+func decodeJSON(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if errors.Is(err, middleware.ErrBodyTooLarge) {
+			return err
+		}
+		return errTrailingData
+	}
+	return nil
+}
+
+// decodeRequest decodes the JSON body into req. It answers 413 when the body
+// exceeds the limit and 400 on any other failure.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	err := decodeJSON(r.Body, req)
+	if err == nil {
+		return true
+	}
+	logger.FromContext(r.Context()).Warn("failed to decode request", "err", err)
+	if errors.Is(err, middleware.ErrBodyTooLarge) {
+		middleware.WriteProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return false
+	}
+	middleware.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+	return false
+}
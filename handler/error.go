@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// statusFromError maps errors returned by the services to HTTP status codes.
+func statusFromError(err error) int {
+	var (
+		notFound  *model.ErrNotFound
+		forbidden *model.ErrForbidden
+		expired   *model.ErrInviteExpired
+		badURL    *model.ErrInvalidWebhookURL
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &forbidden):
+		return http.StatusForbidden
+	case errors.As(err, &expired):
+		return http.StatusGone
+	case errors.As(err, &badURL):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, context.DeadlineExceeded):
+		// the route timeout canceled the query
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -2,9 +2,10 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/logger"
 	"github.com/TechBowl-japan/go-stations/model"
 )
 
@@ -21,6 +22,51 @@ func (h *HealthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	response := &model.HealthzResponse{Message: "OK"}
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(response); err != nil {
-		log.Println(err)
+		logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
+	}
+}
+
+// A LivezHandler implements liveness probe endpoint. It only tells that the
+// process serves requests and never checks dependencies.
+type LivezHandler struct{}
+
+// NewLivezHandler returns LivezHandler based http.Handler.
+func NewLivezHandler() *LivezHandler {
+	return &LivezHandler{}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *LivezHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, &model.HealthResponse{Status: health.StatusPass}, http.StatusOK)
+}
+
+// A ReadyzHandler implements readiness probe endpoint running the checks of
+// a health.Health.
+type ReadyzHandler struct {
+	health *health.Health
+}
+
+// NewReadyzHandler returns ReadyzHandler based http.Handler.
+func NewReadyzHandler(h *health.Health) *ReadyzHandler {
+	return &ReadyzHandler{health: h}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *ReadyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response, ok := h.health.Check(r.Context())
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+		logger.FromContext(r.Context()).Warn("not ready", "checks", response.Checks)
+	}
+	writeHealth(w, r, response, status)
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, response *model.HealthResponse, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
 	}
 }
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A ListHandler implements handling REST endpoints of shared lists.
+type ListHandler struct {
+	svc *service.ListService
+}
+
+// NewListHandler returns ListHandler based http.Handler.
+func NewListHandler(svc *service.ListService) *ListHandler {
+	return &ListHandler{
+		svc: svc,
+	}
+}
+
+func (h *ListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		lists, err := h.svc.ReadLists(r.Context())
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read lists", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.ReadListResponse{Lists: lists})
+	case http.MethodPost:
+		req := &model.CreateListRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		list, err := h.svc.CreateList(r.Context(), req.Name)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to create list", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.CreateListResponse{List: *list})
+	case http.MethodPut:
+		req := &model.UpdateListRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ID == 0 || req.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		list, err := h.svc.UpdateList(r.Context(), req.ID, req.Name)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to update list", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.UpdateListResponse{List: *list})
+	case http.MethodDelete:
+		req := &model.DeleteListRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ID == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.svc.DeleteList(r.Context(), req.ID); err != nil {
+			logger.FromContext(r.Context()).Error("failed to delete list", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.DeleteListResponse{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// A ListMemberHandler implements handling REST endpoints of list members.
+type ListMemberHandler struct {
+	svc *service.ListService
+}
+
+// NewListMemberHandler returns ListMemberHandler based http.Handler.
+func NewListMemberHandler(svc *service.ListService) *ListMemberHandler {
+	return &ListMemberHandler{
+		svc: svc,
+	}
+}
+
+func (h *ListMemberHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listID, err := strconv.ParseInt(r.URL.Query().Get("list_id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		members, err := h.svc.ReadMembers(r.Context(), listID)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read list members", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.ReadListMemberResponse{Members: members})
+	case http.MethodPut:
+		req := &model.PutListMemberRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ListID == 0 || req.UserID == "" || !req.Permission.Valid() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		member, err := h.svc.PutMember(r.Context(), req.ListID, req.UserID, req.Permission)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to put list member", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.PutListMemberResponse{Member: *member})
+	case http.MethodDelete:
+		req := &model.DeleteListMemberRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ListID == 0 || req.UserID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.svc.DeleteMember(r.Context(), req.ListID, req.UserID); err != nil {
+			logger.FromContext(r.Context()).Error("failed to delete list member", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.DeleteListMemberResponse{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// A ListInviteHandler implements handling REST endpoints of list invites.
+type ListInviteHandler struct {
+	svc *service.ListService
+}
+
+// NewListInviteHandler returns ListInviteHandler based http.Handler.
+func NewListInviteHandler(svc *service.ListService) *ListInviteHandler {
+	return &ListInviteHandler{
+		svc: svc,
+	}
+}
+
+func (h *ListInviteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listID, err := strconv.ParseInt(r.URL.Query().Get("list_id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		invites, err := h.svc.ReadInvites(r.Context(), listID)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read list invites", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.ReadListInviteResponse{Invites: invites})
+	case http.MethodPost:
+		req := &model.CreateListInviteRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ListID == 0 || req.TTLSeconds < 0 ||
+			(req.Permission != model.PermissionViewer && req.Permission != model.PermissionEditor) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ttl := time.Duration(req.TTLSeconds) * time.Second
+		invite, err := h.svc.CreateInvite(r.Context(), req.ListID, req.Permission, ttl)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to create list invite", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.CreateListInviteResponse{Invite: *invite})
+	case http.MethodDelete:
+		req := &model.DeleteListInviteRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.Token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.svc.DeleteInvite(r.Context(), req.Token); err != nil {
+			logger.FromContext(r.Context()).Error("failed to delete list invite", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.DeleteListInviteResponse{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// A ListInviteAcceptHandler implements joining a list with an invite token.
+type ListInviteAcceptHandler struct {
+	svc *service.ListService
+}
+
+// NewListInviteAcceptHandler returns ListInviteAcceptHandler based http.Handler.
+func NewListInviteAcceptHandler(svc *service.ListService) *ListInviteAcceptHandler {
+	return &ListInviteAcceptHandler{
+		svc: svc,
+	}
+}
+
+func (h *ListInviteAcceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req := &model.AcceptListInviteRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	member, err := h.svc.AcceptInvite(r.Context(), req.Token)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to accept list invite", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	encodeResponse(w, r, &model.AcceptListInviteResponse{Member: *member})
+}
+
+// encodeResponse writes res as the JSON body.
+func encodeResponse(w http.ResponseWriter, r *http.Request, res interface{}) {
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A LogLevelHandler implements reading and changing the log level at runtime.
+type LogLevelHandler struct {
+	level *logger.LevelVar
+}
+
+// NewLogLevelHandler returns LogLevelHandler based http.Handler.
+func NewLogLevelHandler(level *logger.LevelVar) *LogLevelHandler {
+	return &LogLevelHandler{
+		level: level,
+	}
+}
+
+func (h *LogLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		encodeResponse(w, r, &model.LogLevelResponse{Level: h.level.Level().String()})
+	case http.MethodPut:
+		req := &model.LogLevelRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		level, err := logger.ParseLevel(req.Level)
+		if err != nil || req.Level == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		previous := h.level.Level()
+		h.level.Set(level)
+		logger.FromContext(r.Context()).Warn("log level changed", "from", previous.String(), "to", level.String())
+		encodeResponse(w, r, &model.LogLevelResponse{Level: level.String()})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -1,39 +1,55 @@
 package middleware
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/logger"
 	"github.com/TechBowl-japan/go-stations/model"
 )
 
-func GetAccessLog(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// NewAccessLog returns a middleware that writes the access log of every request to sink.
+func NewAccessLog(sink AccessLogSink) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := newResponseWriter(w)
+			ctx, user := auth.RecordUser(r.Context())
 
-		h.ServeHTTP(w, r)
+			h.ServeHTTP(rw, r.WithContext(ctx))
 
-		res := &model.AccessLog{
-			Timestamp: start,
-			Latency:   time.Since(start).Milliseconds(),
-			Path:      r.URL.Path,
-		}
-		OS, err := OSFromContext(r.Context())
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		res.OS = OS
+			res := &model.AccessLog{
+				Timestamp: start,
+				Latency:   time.Since(start).Milliseconds(),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Query:     r.URL.RawQuery,
+				Proto:     r.Proto,
+				Status:    rw.status,
+				Bytes:     rw.bytes,
+				RemoteIP:  clientIP(r),
+				UserAgent: r.UserAgent(),
+				Referer:   r.Referer(),
+				User:      user(),
+				RequestID: requestID(r),
+			}
+			if OS, err := OSFromContext(r.Context()); err == nil {
+				res.OS = OS
+			}
 
-		bytes, err := json.Marshal(res)
-		if err != nil {
-			log.Println(err)
-			return
+			if err := sink.Write(res); err != nil {
+				logger.FromContext(r.Context()).Error("failed to write access log", "err", err)
+			}
 		}
-		fmt.Println(string(bytes))
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
+}
+
+var stdoutAccessLog, _ = NewAccessLogSink("json", os.Stdout)
+
+// GetAccessLog writes the access log of every request to stdout as JSON.
+func GetAccessLog(h http.Handler) http.Handler {
+	return NewAccessLog(stdoutAccessLog)(h)
 }
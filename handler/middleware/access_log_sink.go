@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// An AccessLogSink receives the access log of every request.
+type AccessLogSink interface {
+	Write(l *model.AccessLog) error
+}
+
+// NewAccessLogSink returns a sink writing to w in the given format, either
+// "json" (one object per line) or "combined" (Apache combined log format).
+func NewAccessLogSink(format string, w io.Writer) (AccessLogSink, error) {
+	switch format {
+	case "", "json":
+		return &jsonSink{w: w}, nil
+	case "combined":
+		return &combinedSink{w: w}, nil
+	default:
+		return nil, fmt.Errorf("middleware: unknown access log format %q", format)
+	}
+}
+
+// OpenAccessLogSink returns a sink for the given format writing to path,
+// appending to the file, or to stdout when path is empty.
+func OpenAccessLogSink(format, path string) (AccessLogSink, io.Closer, error) {
+	if path == "" {
+		sink, err := NewAccessLogSink(format, os.Stdout)
+		return sink, io.NopCloser(nil), err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	sink, err := NewAccessLogSink(format, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return sink, f, nil
+}
+
+type jsonSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *jsonSink) Write(l *model.AccessLog) error {
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(bytes, '\n'))
+	return err
+}
+
+type combinedSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *combinedSink) Write(l *model.AccessLog) error {
+	target := l.Path
+	if l.Query != "" {
+		target += "?" + l.Query
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		dash(l.RemoteIP), dash(l.User), l.Timestamp.Format("02/Jan/2006:15:04:05 -0700"),
+		l.Method, escapeQuotes(target), l.Proto, l.Status, bytesField(l.Bytes),
+		dash(escapeQuotes(l.Referer)), dash(escapeQuotes(l.UserAgent)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, line)
+	return err
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+func escapeQuotes(s string) string {
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
@@ -0,0 +1,93 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestAccessLogSink(t *testing.T) {
+	t.Parallel()
+
+	at := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("JST", 9*60*60))
+	full := &model.AccessLog{
+		Timestamp: at,
+		Latency:   12,
+		Method:    "GET",
+		Path:      "/todos",
+		Query:     `q="x"`,
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     345,
+		RemoteIP:  "192.0.2.1",
+		UserAgent: `curl "7"`,
+		Referer:   "https://example.com/",
+		User:      "alice",
+		RequestID: "abc",
+		OS:        "linux",
+	}
+	bare := &model.AccessLog{
+		Timestamp: at,
+		Method:    "POST",
+		Path:      "/todos",
+		Proto:     "HTTP/2.0",
+		Status:    401,
+		RemoteIP:  "192.0.2.1",
+	}
+
+	cases := map[string]struct {
+		format string
+		log    *model.AccessLog
+		want   string
+	}{
+		"Combined": {
+			format: "combined",
+			log:    full,
+			want:   `192.0.2.1 - alice [04/Mar/2022:05:06:07 +0900] "GET /todos?q=\"x\" HTTP/1.1" 200 345 "https://example.com/" "curl \"7\""` + "\n",
+		},
+		"Combined without optional fields": {
+			format: "combined",
+			log:    bare,
+			want:   `192.0.2.1 - - [04/Mar/2022:05:06:07 +0900] "POST /todos HTTP/2.0" 401 - "-" "-"` + "\n",
+		},
+		"JSON": {
+			format: "json",
+			log:    full,
+			want:   `{"timestamp":"2022-03-04T05:06:07+09:00","latency":12,"method":"GET","path":"/todos","query":"q=\"x\"","proto":"HTTP/1.1","status":200,"bytes":345,"remote_ip":"192.0.2.1","user_agent":"curl \"7\"","referer":"https://example.com/","user":"alice","request_id":"abc","os":"linux"}` + "\n",
+		},
+		"JSON without optional fields": {
+			format: "",
+			log:    bare,
+			want:   `{"timestamp":"2022-03-04T05:06:07+09:00","latency":0,"method":"POST","path":"/todos","proto":"HTTP/2.0","status":401,"bytes":0,"remote_ip":"192.0.2.1","os":""}` + "\n",
+		},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			sink, err := middleware.NewAccessLogSink(tc.format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Write(tc.log); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("wrote\n%s\nwant\n%s", got, tc.want)
+			}
+			if strings.HasPrefix(tc.want, "{") && !json.Valid(buf.Bytes()) {
+				t.Errorf("wrote invalid JSON %s", buf.String())
+			}
+		})
+	}
+
+	if _, err := middleware.NewAccessLogSink("common", &bytes.Buffer{}); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// memorySink keeps the access logs written to it.
+type memorySink struct {
+	mu   sync.Mutex
+	logs []*model.AccessLog
+}
+
+func (s *memorySink) Write(l *model.AccessLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, l)
+	return nil
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		// inner runs where authentication would
+		inner    func(h http.Handler) http.Handler
+		status   int
+		body     string
+		wantUser string
+	}{
+		"Authenticated further down": {
+			inner: func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), "alice")))
+				})
+			},
+			status:   http.StatusCreated,
+			body:     `{"todo":{}}`,
+			wantUser: "alice",
+		},
+		"Anonymous": {
+			inner:  func(h http.Handler) http.Handler { return h },
+			status: http.StatusUnauthorized,
+			body:   "Not authorized",
+		},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sink := &memorySink{}
+			h := middleware.RequestID(middleware.NewAccessLog(sink)(tc.inner(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))))
+			r := httptest.NewRequest(http.MethodPost, "/todos?list_id=1", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("User-Agent", "test")
+			r.Header.Set(middleware.RequestIDHeader, "req-1")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if len(sink.logs) != 1 {
+				t.Fatalf("wrote %d logs, want 1", len(sink.logs))
+			}
+			got := sink.logs[0]
+			if got.Method != http.MethodPost || got.Path != "/todos" || got.Query != "list_id=1" ||
+				got.Status != tc.status || got.Bytes != int64(len(tc.body)) ||
+				got.RemoteIP != "192.0.2.1" || got.UserAgent != "test" || got.RequestID != "req-1" ||
+				got.User != tc.wantUser {
+				t.Errorf("logged %+v", got)
+			}
+		})
+	}
+}
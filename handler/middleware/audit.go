@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// WriteAuditLog prints the audit record as a JSON line, like GetAccessLog does.
+func WriteAuditLog(a *model.AuditLog) {
+	bytes, err := json.Marshal(a)
+	if err != nil {
+		logger.Default().Error("failed to encode audit log", "err", err)
+		return
+	}
+	fmt.Println(string(bytes))
+}
@@ -4,22 +4,55 @@ import (
 	"crypto/subtle"
 	"net/http"
 	"os"
+
+	"github.com/TechBowl-japan/go-stations/auth"
 )
 
-func checkAuth(r *http.Request) bool {
-	user, pass, ok := r.BasicAuth()
-	return ok && subtle.ConstantTimeCompare([]byte(user), []byte(os.Getenv("BASIC_AUTH_USER_ID"))) == 1 &&
+// A Credentials verifies the username and password sent with Basic authentication.
+type Credentials interface {
+	Verify(user, pass string) bool
+}
+
+// EnvCredentials accepts the single pair set in BASIC_AUTH_USER_ID and BASIC_AUTH_PASSWORD.
+type EnvCredentials struct{}
+
+// Verify implements Credentials interface.
+func (EnvCredentials) Verify(user, pass string) bool {
+	return user != "" && subtle.ConstantTimeCompare([]byte(user), []byte(os.Getenv("BASIC_AUTH_USER_ID"))) == 1 &&
 		subtle.ConstantTimeCompare([]byte(pass), []byte(os.Getenv("BASIC_AUTH_PASSWORD"))) == 1
 }
 
-func BasicAuth(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		if !checkAuth(r) {
-			w.Header().Add("WWW-Authenticate", `Basic realm="my private area"`)
-			http.Error(w, "Not authorized", http.StatusUnauthorized)
-			return
+// NewBasicAuth returns a middleware that requires Basic credentials accepted by c.
+// Empty usernames are refused whatever c accepts.
+func NewBasicAuth(c Credentials) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user == "" || !c.Verify(user, pass) {
+				w.Header().Add("WWW-Authenticate", `Basic realm="my private area"`)
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(auth.ContextWithUser(r.Context(), user))
+			h.ServeHTTP(w, r)
 		}
-		h.ServeHTTP(w, r)
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
+}
+
+// BasicAuth requires the Basic credentials configured with EnvCredentials.
+func BasicAuth(h http.Handler) http.Handler {
+	return NewBasicAuth(EnvCredentials{})(h)
+}
+
+// StaticCredentials accepts the single configured pair.
+type StaticCredentials struct {
+	UserID   string
+	Password string
+}
+
+// Verify implements Credentials interface.
+func (c StaticCredentials) Verify(user, pass string) bool {
+	return user != "" && subtle.ConstantTimeCompare([]byte(user), []byte(c.UserID)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(c.Password)) == 1
 }
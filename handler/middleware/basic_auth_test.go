@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+// anyCredentials accepts every username and password.
+type anyCredentials struct{}
+
+func (anyCredentials) Verify(user, pass string) bool { return true }
+
+func TestBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		creds    middleware.Credentials
+		user     string
+		pass     string
+		noHeader bool
+		want     int
+	}{
+		"Accepted":                   {creds: middleware.StaticCredentials{UserID: "u", Password: "p"}, user: "u", pass: "p", want: http.StatusOK},
+		"Wrong password":             {creds: middleware.StaticCredentials{UserID: "u", Password: "p"}, user: "u", pass: "x", want: http.StatusUnauthorized},
+		"No credentials":             {creds: middleware.StaticCredentials{UserID: "u", Password: "p"}, noHeader: true, want: http.StatusUnauthorized},
+		"Empty pair configured":      {creds: middleware.StaticCredentials{}, want: http.StatusUnauthorized},
+		"Empty user with a password": {creds: middleware.StaticCredentials{Password: "p"}, pass: "p", want: http.StatusUnauthorized},
+		"Empty user accepted by c":   {creds: anyCredentials{}, want: http.StatusUnauthorized},
+		"Environment, empty pair":    {creds: middleware.EnvCredentials{}, want: http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var gotUser string
+			h := middleware.NewBasicAuth(tc.creds)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = auth.UserFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if !tc.noHeader {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && gotUser != tc.user {
+				t.Errorf("user = %q, want %q", gotUser, tc.user)
+			}
+		})
+	}
+}
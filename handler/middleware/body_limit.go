@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge is returned when reading a request body beyond the limit
+// set by NewBodyLimit.
+var ErrBodyTooLarge = errors.New("middleware: request body too large")
+
+// NewBodyLimit returns a middleware that limits request bodies to n bytes.
+// Requests declaring a longer Content-Length are answered 413 right away;
+// otherwise reading past n fails with ErrBodyTooLarge, which handlers should
+// answer with 413 too.
+func NewBodyLimit(n int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not exceed %d bytes", n))
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, n), limit: n}
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// limitedBody turns the error of http.MaxBytesReader into ErrBodyTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
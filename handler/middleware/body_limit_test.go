@@ -0,0 +1,45 @@
+package middleware_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	h := middleware.NewBodyLimit(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); errors.Is(err, middleware.ErrBodyTooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	cases := map[string]struct {
+		body          string
+		contentLength int64
+		want          int
+	}{
+		"Within limit":              {body: "12345678", contentLength: 8, want: http.StatusOK},
+		"Declared too long":         {body: "123456789", contentLength: 9, want: http.StatusRequestEntityTooLarge},
+		"Too long without a length": {body: "123456789", contentLength: -1, want: http.StatusRequestEntityTooLarge},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(tc.body))
+			req.ContentLength = tc.contentLength
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
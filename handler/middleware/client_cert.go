@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+)
+
+// A ClientCertUser maps a verified client certificate to a user, or returns
+// an empty string when the certificate names none.
+type ClientCertUser func(cert *x509.Certificate) string
+
+// ClientCertUserByField returns the ClientCertUser reading field, either
+// "cn" for the subject common name or "email" for the first email SAN.
+func ClientCertUserByField(field string) (ClientCertUser, error) {
+	switch field {
+	case "", "cn":
+		return func(cert *x509.Certificate) string { return cert.Subject.CommonName }, nil
+	case "email":
+		return func(cert *x509.Certificate) string {
+			if len(cert.EmailAddresses) == 0 {
+				return ""
+			}
+			return cert.EmailAddresses[0]
+		}, nil
+	default:
+		return nil, fmt.Errorf("middleware: unknown client certificate field %q", field)
+	}
+}
+
+// NewClientCertAuth returns a middleware that authenticates requests with a
+// client certificate verified by the TLS handshake as the user mapped by
+// user. Other requests are passed to fallback, such as Basic authentication.
+func NewClientCertAuth(user ClientCertUser, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		guarded := fallback(h)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+			name := user(r.TLS.VerifiedChains[0][0])
+			if name == "" {
+				WriteProblem(w, r, http.StatusForbidden, "The client certificate does not name a user")
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), name)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	t.Parallel()
+
+	cnUser, err := middleware.ClientCertUserByField("cn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailUser, err := middleware.ClientCertUserByField("email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := middleware.ClientCertUserByField("serial"); err == nil {
+		t.Error("unknown field was accepted")
+	}
+	// the fallback marks the requests it was passed
+	fallback := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), "fallback")))
+		})
+	}
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, EmailAddresses: []string{"alice@example.com"}}
+
+	cases := map[string]struct {
+		user     middleware.ClientCertUser
+		tls      *tls.ConnectionState
+		want     int
+		wantUser string
+	}{
+		"Plain HTTP":     {user: cnUser, want: http.StatusOK, wantUser: "fallback"},
+		"No certificate": {user: cnUser, tls: &tls.ConnectionState{}, want: http.StatusOK, wantUser: "fallback"},
+		"Common name":    {user: cnUser, tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}, want: http.StatusOK, wantUser: "alice"},
+		"Email":          {user: emailUser, tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{alice}}}, want: http.StatusOK, wantUser: "alice@example.com"},
+		"No common name": {user: cnUser, tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}, want: http.StatusForbidden},
+		"No email":       {user: emailUser, tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}}}, want: http.StatusForbidden},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var gotUser string
+			h := middleware.NewClientCertAuth(tc.user, fallback)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = auth.UserFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			req.TLS = tc.tls
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if gotUser != tc.wantUser {
+				t.Errorf("user = %q, want %q", gotUser, tc.wantUser)
+			}
+		})
+	}
+}
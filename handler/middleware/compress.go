@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressMinSize is the size under which responses are not worth
+// compressing.
+const DefaultCompressMinSize = 1024
+
+// compressibleTypes are the media types worth compressing. Event streams
+// are left alone as proxies buffer compressed ones.
+var compressibleTypes = map[string]bool{
+	"application/json":         true,
+	"application/problem+json": true,
+	"application/x-ndjson":     true,
+	"application/msgpack":      true,
+	"application/yaml":         true,
+	"application/xml":          true,
+	"text/csv":                 true,
+	"text/html":                true,
+	"text/plain":               true,
+}
+
+// An encoder compresses the response body.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// encodings are the content codings supported, in order of preference.
+var encodings = []struct {
+	name string
+	new  func(w io.Writer) encoder
+}{
+	{"br", func(w io.Writer) encoder { return brotli.NewWriterLevel(w, 4) }},
+	{"gzip", func(w io.Writer) encoder { return gzip.NewWriter(w) }},
+}
+
+// acceptEncoding returns the preferred content coding among those accepted
+// by the Accept-Encoding header value, or "" for none.
+func acceptEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			name, params = part[:i], part[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if p := strings.TrimSpace(params); strings.HasPrefix(p, "q=") {
+			if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				weight = v
+			}
+		}
+		q[name] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, e := range encodings {
+		weight, ok := q[e.name]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = e.name, weight
+		}
+	}
+	return best
+}
+
+// NewCompress returns a middleware compressing responses of compressible
+// types with the coding preferred by the client, once they reach minSize
+// bytes. Smaller responses are sent as they are.
+func NewCompress(minSize int) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			coding := acceptEncoding(r.Header.Get("Accept-Encoding"))
+			// upgraded connections, such as WebSockets, are not HTTP bodies
+			if coding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, coding: coding, minSize: minSize, status: http.StatusOK}
+			defer cw.close()
+			h.ServeHTTP(cw, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// A compressWriter buffers the beginning of the body until it knows whether
+// to compress it: when it reaches minSize bytes, or the handler flushes.
+type compressWriter struct {
+	http.ResponseWriter
+	coding  string
+	minSize int
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+	decided     bool
+	enc         encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		// no body to compress
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the header, compressing the body when large is set and the
+// response is compressible, then the buffered beginning of the body.
+func (w *compressWriter) decide(large bool) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+	if large && w.compressible() {
+		w.Header().Set("Content-Encoding", w.coding)
+		w.Header().Del("Content-Length")
+		for _, e := range encodings {
+			if e.name == w.coding {
+				w.enc = e.new(w.ResponseWriter)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		// sniffed here, as the server would sniff the compressed bytes
+		ct = http.DetectContentType(w.buf)
+		h.Set("Content-Type", ct)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	return err == nil && compressibleTypes[mediaType]
+}
+
+// Flush implements http.Flusher interface. A response flushed before
+// reaching minSize is streamed, and compressed as such.
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.decide(true)
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close sends what the handler left buffered and ends the compressed body.
+func (w *compressWriter) close() {
+	if !w.wroteHeader {
+		// the handler wrote nothing; the server answers 200 itself
+		return
+	}
+	w.decide(false)
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
@@ -0,0 +1,138 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat(`{"subject":"todo"}`, 100)
+	cases := map[string]struct {
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		"gzip":                 {acceptEncoding: "gzip", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		"brotli preferred":     {acceptEncoding: "gzip, br", contentType: "application/json", body: large, wantEncoding: "br"},
+		"weighted":             {acceptEncoding: "br;q=0.5, gzip", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		"refused":              {acceptEncoding: "br;q=0, gzip;q=0", contentType: "application/json", body: large},
+		"not accepted":         {contentType: "application/json", body: large},
+		"below the threshold":  {acceptEncoding: "gzip", contentType: "application/json", body: `{"subject":"todo"}`},
+		"incompressible type":  {acceptEncoding: "gzip", contentType: "image/png", body: large},
+		"sniffed content type": {acceptEncoding: "gzip", body: large, wantEncoding: "gzip"},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			h := middleware.NewCompress(middleware.DefaultCompressMinSize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				// written in pieces, as encoders do
+				for i := 0; i < len(tc.body); i += 100 {
+					end := i + 100
+					if end > len(tc.body) {
+						end = len(tc.body)
+					}
+					io.WriteString(w, tc.body[i:end])
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tc.wantEncoding)
+			}
+			if got := decode(t, tc.wantEncoding, rec.Body); got != tc.body {
+				t.Errorf("body = %q, want %q", got, tc.body)
+			}
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	t.Parallel()
+
+	lines := make(chan string, 1)
+	h := middleware.NewCompress(middleware.DefaultCompressMinSize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for line := range lines {
+			io.WriteString(w, line)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// set explicitly, so that the transport does not decompress itself
+	req.Header.Set("Accept-Encoding", "gzip")
+	lines <- "{\"id\":1}\n"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each flushed line is readable before the response ends
+	for i, want := range []string{"{\"id\":1}\n", "{\"id\":2}\n"} {
+		if i > 0 {
+			lines <- want
+		}
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(zr, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != want {
+			t.Errorf("line = %q, want %q", buf, want)
+		}
+	}
+	close(lines)
+}
+
+func decode(t *testing.T, encoding string, r io.Reader) string {
+	t.Helper()
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(r)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
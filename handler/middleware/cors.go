@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CORSConfig expresses which cross-origin requests browsers may send.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed, such as
+	// "https://app.example.com". One "*" matches any part of an origin,
+	// e.g. "https://*.example.com", and "*" alone matches every origin.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods allowed besides the simple ones, GET,
+	// HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed, or "*" for any.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry credentials, such as the
+	// Authorization header. It cannot be set along with the origin "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// DefaultCORSConfig returns a configuration allowing the methods and headers
+// of the API, for no origin.
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Idempotency-Key", "If-None-Match", "If-Modified-Since", RequestIDHeader},
+		ExposedHeaders: []string{RequestIDHeader, "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed", "ETag"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// An originPattern matches origins starting with prefix and ending with
+// suffix, with something in between when wildcard.
+type originPattern struct {
+	prefix, suffix string
+	wildcard       bool
+}
+
+func (p originPattern) match(origin string) bool {
+	if !p.wildcard {
+		return origin == p.prefix
+	}
+	return len(origin) > len(p.prefix)+len(p.suffix) &&
+		strings.HasPrefix(origin, p.prefix) && strings.HasSuffix(origin, p.suffix)
+}
+
+// NewCORS returns a middleware answering CORS preflight requests itself, so
+// that they are not rejected by authentication further down the chain, and
+// adding the CORS headers to the responses to allowed origins.
+func NewCORS(cfg CORSConfig) (func(http.Handler) http.Handler, error) {
+	var (
+		anyOrigin bool
+		patterns  []originPattern
+	)
+	for _, o := range cfg.AllowedOrigins {
+		o = strings.ToLower(strings.TrimSpace(o))
+		switch n := strings.Count(o, "*"); {
+		case o == "*":
+			anyOrigin = true
+		case n == 0:
+			patterns = append(patterns, originPattern{prefix: o})
+		case n == 1:
+			i := strings.Index(o, "*")
+			patterns = append(patterns, originPattern{prefix: o[:i], suffix: o[i+1:], wildcard: true})
+		default:
+			return nil, fmt.Errorf("middleware: invalid CORS origin %q, only one * is allowed", o)
+		}
+	}
+	if anyOrigin && cfg.AllowCredentials {
+		// it would let every site act with the credentials of the user
+		return nil, fmt.Errorf("middleware: CORS origin * cannot allow credentials, list the origins instead")
+	}
+
+	methods := map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodPost: true}
+	for _, m := range cfg.AllowedMethods {
+		methods[strings.ToUpper(strings.TrimSpace(m))] = true
+	}
+	anyHeader := false
+	headers := map[string]bool{}
+	for _, h := range cfg.AllowedHeaders {
+		if h = strings.TrimSpace(h); h == "*" {
+			anyHeader = true
+		}
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+	allowMethods := strings.Join(cfg.AllowedMethods, ", ")
+	exposeHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	allowed := func(origin string) bool {
+		if anyOrigin {
+			return true
+		}
+		origin = strings.ToLower(origin)
+		for _, p := range patterns {
+			if p.match(origin) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			// the answer depends on the origin, so caches must not share it
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !allowed(origin) {
+				if preflight {
+					WriteProblem(w, r, http.StatusForbidden, "The origin is not allowed")
+					return
+				}
+				// without CORS headers, browsers withhold the response
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				// credentialed requests need the exact origin
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if exposeHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+				WriteProblem(w, r, http.StatusForbidden, "The method is not allowed")
+				return
+			}
+			var requested []string
+			for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+				for _, name := range strings.Split(v, ",") {
+					if name = strings.TrimSpace(name); name == "" {
+						continue
+					}
+					if !anyHeader && !headers[http.CanonicalHeaderKey(name)] {
+						WriteProblem(w, r, http.StatusForbidden, fmt.Sprintf("The header %s is not allowed", name))
+						return
+					}
+					requested = append(requested, name)
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			if len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
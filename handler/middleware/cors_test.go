@@ -0,0 +1,114 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+
+	cfg := middleware.DefaultCORSConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com", "https://*.preview.example.com"}
+	cfg.AllowCredentials = true
+	cfg.MaxAge = time.Hour
+	cors, err := middleware.NewCORS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// preflights must be answered before authentication rejects them
+	h := cors(middleware.NewBasicAuth(middleware.StaticCredentials{UserID: "u", Password: "p"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+
+	tests := map[string]struct {
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		wantStatus  int
+		wantOrigin  string
+		wantHeaders string
+	}{
+		"preflight": {
+			method: http.MethodOptions, origin: "https://app.example.com",
+			reqMethod: http.MethodPut, reqHeaders: "authorization, content-type",
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantHeaders: "authorization, content-type",
+		},
+		"preflight from wildcard origin": {
+			method: http.MethodOptions, origin: "https://pr-1.preview.example.com", reqMethod: http.MethodDelete,
+			wantStatus: http.StatusNoContent, wantOrigin: "https://pr-1.preview.example.com",
+		},
+		"preflight from other origin": {
+			method: http.MethodOptions, origin: "https://evil.example.com", reqMethod: http.MethodGet,
+			wantStatus: http.StatusForbidden,
+		},
+		"preflight with method not allowed": {
+			method: http.MethodOptions, origin: "https://app.example.com", reqMethod: http.MethodPatch,
+			wantStatus: http.StatusForbidden, wantOrigin: "https://app.example.com",
+		},
+		"preflight with header not allowed": {
+			method: http.MethodOptions, origin: "https://app.example.com", reqMethod: http.MethodGet, reqHeaders: "X-Secret",
+			wantStatus: http.StatusForbidden, wantOrigin: "https://app.example.com",
+		},
+		"actual request": {
+			method: http.MethodGet, origin: "https://app.example.com",
+			wantStatus: http.StatusUnauthorized, wantOrigin: "https://app.example.com",
+		},
+		"actual request from other origin": {
+			method: http.MethodGet, origin: "https://preview.example.com",
+			wantStatus: http.StatusUnauthorized,
+		},
+		"same origin OPTIONS": {
+			method:     http.MethodOptions,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for name, tc := range tests {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(tc.method, "/todos", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.reqMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tc.reqMethod)
+			}
+			if tc.reqHeaders != "" {
+				r.Header.Set("Access-Control-Request-Headers", tc.reqHeaders)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tc.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != tc.wantHeaders {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, tc.wantHeaders)
+			}
+			if tc.wantStatus == http.StatusNoContent {
+				if got := w.Header().Get("Access-Control-Max-Age"); got != "3600" {
+					t.Errorf("Access-Control-Max-Age = %q, want 3600", got)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+					t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+				}
+			}
+		})
+	}
+
+	if _, err := middleware.NewCORS(middleware.CORSConfig{AllowedOrigins: []string{"https://*.*.example.com"}}); err == nil {
+		t.Error("origin with two wildcards was accepted")
+	}
+	if _, err := middleware.NewCORS(middleware.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("any origin was allowed credentials")
+	}
+}
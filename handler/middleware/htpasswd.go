@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// An Htpasswd holds the users of an Apache htpasswd file. bcrypt ($2y$),
+// SHA1 ({SHA}) and APR1-MD5 ($apr1$) entries are supported.
+type Htpasswd struct {
+	path string
+
+	// Heartbeat, when set before Watch starts, is beaten on every check.
+	Heartbeat *health.Heartbeat
+
+	mu      sync.RWMutex
+	users   map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// NewHtpasswd loads the htpasswd file at path.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload reads the file again. The previously loaded users are kept on error.
+func (h *Htpasswd) Reload() error {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return fmt.Errorf("htpasswd: %s:%d: malformed entry", h.path, n)
+		}
+		user, hash := line[:i], line[i+1:]
+		if !supportedHash(hash) {
+			logger.Default().Warn("unsupported htpasswd entry ignored", "path", h.path, "line", n, "user", user)
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.users = users
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+	return nil
+}
+
+// Verify implements Credentials interface.
+func (h *Htpasswd) Verify(user, pass string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1MD5(pass, salt))) == 1
+	default:
+		return false
+	}
+}
+
+// Watch reloads the file on SIGHUP and whenever its size or modification
+// time changes, checking every interval until ctx is done.
+func (h *Htpasswd) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.Heartbeat.Beat()
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			info, err := os.Stat(h.path)
+			if err != nil {
+				logger.Default().Error("failed to stat htpasswd file", "path", h.path, "err", err)
+				continue
+			}
+			h.mu.RLock()
+			changed := !info.ModTime().Equal(h.modTime) || info.Size() != h.size
+			h.mu.RUnlock()
+			if !changed {
+				continue
+			}
+		}
+		if err := h.Reload(); err != nil {
+			logger.Default().Error("failed to reload htpasswd file, keeping previous users", "path", h.path, "err", err)
+			continue
+		}
+		logger.Default().Info("htpasswd file reloaded", "path", h.path)
+	}
+}
+
+func supportedHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "{SHA}", "$apr1$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apr1MD5 implements the Apache variant of the MD5-based crypt(3).
+func apr1MD5(password, salt string) string {
+	const (
+		magic  = "$apr1$"
+		itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	)
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		n := i
+		if n > 16 {
+			n = 16
+		}
+		ctx.Write(altSum[:n])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return b.String()
+}
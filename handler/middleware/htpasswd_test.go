@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# generated by htpasswd\n" +
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+		"apr1:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/\n" +
+		"bcrypt:" + string(bcryptHash) + "\n" +
+		"plain:secret\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal("failed to load htpasswd, err =", err)
+	}
+
+	cases := map[string]struct {
+		user, pass string
+		want       bool
+	}{
+		"SHA1":              {user: "sha", pass: "secret", want: true},
+		"APR1-MD5":          {user: "apr1", pass: "secret", want: true},
+		"bcrypt":            {user: "bcrypt", pass: "secret", want: true},
+		"Wrong password":    {user: "apr1", pass: "Secret", want: false},
+		"Unsupported entry": {user: "plain", pass: "secret", want: false},
+		"Unknown user":      {user: "nobody", pass: "secret", want: false},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			if got := h.Verify(c.user, c.pass); got != c.want {
+				t.Errorf("unexpected value, given = %v, expected = %v", got, c.want)
+			}
+		})
+	}
+}
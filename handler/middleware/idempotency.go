@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// maxIdempotencyKeyLength bounds the Idempotency-Key header.
+	maxIdempotencyKeyLength = 255
+	// idempotencyWait is how long a duplicate waits for the original request.
+	idempotencyWait = 5 * time.Second
+)
+
+// idempotentHeaders are the response headers recorded for replays. They
+// describe the resource created; the others, such as X-Request-ID or
+// RateLimit-Remaining, belong to the request answering and are set afresh,
+// and Content-Encoding is left to Compress since the body is recorded
+// uncompressed.
+var idempotentHeaders = []string{
+	"Content-Type",
+	"Content-Language",
+	"Location",
+	"ETag",
+	"Last-Modified",
+}
+
+// An IdempotencyStore persists Idempotency-Key records.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, scope, key, requestHash string, ttl time.Duration) (*model.IdempotencyRecord, bool, error)
+	Read(ctx context.Context, scope, key string) (*model.IdempotencyRecord, error)
+	Complete(ctx context.Context, scope string, rec *model.IdempotencyRecord) error
+	Release(ctx context.Context, scope, key string) error
+}
+
+// NewIdempotency returns a middleware that makes POST requests carrying an
+// Idempotency-Key header safe to retry. The first response for a key is
+// recorded for ttl and replayed for repeats, reusing a key with another body
+// is answered with 422, and a repeat arriving while the original is still in
+// progress waits for it or gets 409.
+func NewIdempotency(store IdempotencyStore, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				WriteProblem(w, r, http.StatusBadRequest, "Idempotency-Key is too long")
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if errors.Is(err, ErrBodyTooLarge) {
+				WriteProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			if err != nil {
+				WriteProblem(w, r, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			sum := sha256.New()
+			io.WriteString(sum, r.Method+" "+r.URL.RequestURI()+"\n")
+			sum.Write(body)
+			hash := hex.EncodeToString(sum.Sum(nil))
+			scope, _ := auth.UserFromContext(r.Context())
+
+			rec, reserved, err := store.Reserve(r.Context(), scope, key, hash, ttl)
+			if err != nil {
+				logger.FromContext(r.Context()).Error("failed to reserve idempotency key", "err", err)
+				WriteProblem(w, r, http.StatusInternalServerError, "")
+				return
+			}
+			if !reserved {
+				replayIdempotent(w, r, store, scope, rec, hash)
+				return
+			}
+
+			recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// Let the client retry after failures instead of replaying them.
+				if err := store.Release(context.Background(), scope, key); err != nil {
+					logger.FromContext(r.Context()).Error("failed to release idempotency key", "err", err)
+				}
+			}()
+
+			h.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				return
+			}
+			rec.StatusCode = recorder.status
+			rec.Header = make(http.Header)
+			for _, k := range idempotentHeaders {
+				if v := w.Header().Values(k); len(v) > 0 {
+					rec.Header[k] = append([]string(nil), v...)
+				}
+			}
+			rec.Body = recorder.body.Bytes()
+			if err := store.Complete(context.Background(), scope, rec); err != nil {
+				logger.FromContext(r.Context()).Error("failed to record idempotent response", "err", err)
+				return
+			}
+			completed = true
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, store IdempotencyStore, scope string, rec *model.IdempotencyRecord, hash string) {
+	if rec.RequestHash != hash {
+		WriteProblem(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+		return
+	}
+
+	deadline := time.NewTimer(idempotencyWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for rec.StatusCode == 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			w.Header().Set("Retry-After", "1")
+			WriteProblem(w, r, http.StatusConflict, "A request with the same Idempotency-Key is in progress")
+			return
+		case <-ticker.C:
+		}
+		var (
+			err      error
+			notFound *model.ErrNotFound
+		)
+		rec, err = store.Read(r.Context(), scope, rec.Key)
+		if errors.As(err, &notFound) {
+			// The original request failed and released the key.
+			w.Header().Set("Retry-After", "1")
+			WriteProblem(w, r, http.StatusConflict, "The request with the same Idempotency-Key failed, retry it")
+			return
+		}
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read idempotency key", "err", err)
+			WriteProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	for k, v := range rec.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.StatusCode)
+	if _, err := w.Write(rec.Body); err != nil {
+		logger.FromContext(r.Context()).Error("failed to replay idempotent response", "err", err)
+	}
+}
+
+// A recordingWriter keeps a copy of the status and body written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
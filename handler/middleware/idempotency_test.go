@@ -0,0 +1,133 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestIdempotency(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	var calls int32
+	h := middleware.NewIdempotency(service.NewIdempotencyService(d), time.Hour)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&calls, 1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Location", "/todos/"+string(rune('0'+n)))
+			w.Header().Set("X-Request-ID", "request-"+string(rune('0'+n)))
+			w.Write([]byte(`{"todo":{}}`))
+		}))
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = do("key-1", `{"subject":"a"}`)
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("unexpected number of handler calls, given = %d, expected = 1", calls)
+	}
+	replayed := 0
+	for _, w := range results {
+		if w.Code != http.StatusOK || w.Header().Get("Location") != "/todos/1" {
+			t.Errorf("unexpected response, given = %d %v", w.Code, w.Header())
+		}
+		if w.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+			if v := w.Header().Get("X-Request-ID"); v != "" {
+				t.Errorf("unexpected replayed X-Request-ID, given = %q", v)
+			}
+		}
+	}
+	if replayed != len(results)-1 {
+		t.Errorf("unexpected number of replays, given = %d, expected = %d", replayed, len(results)-1)
+	}
+
+	if w := do("key-1", `{"subject":"b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unexpected status for reused key, given = %d, expected = %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyCompressed(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	body := strings.Repeat(`{"subject":"todo"}`, 100)
+	h := middleware.NewCompress(middleware.DefaultCompressMinSize)(
+		middleware.NewIdempotency(service.NewIdempotencyService(d), time.Hour)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, body)
+			})))
+
+	// the first response is compressed, the replays as each asks
+	for i, acceptEncoding := range []string{"gzip", "", "gzip"} {
+		r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"subject":"a"}`))
+		r.Header.Set("Idempotency-Key", "key-1")
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != (i > 0) {
+			t.Errorf("request %d: unexpected Idempotent-Replayed, given = %t", i, replayed)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != acceptEncoding {
+			t.Errorf("request %d: unexpected Content-Encoding, given = %q, expected = %q", i, got, acceptEncoding)
+		}
+		if got := w.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+			t.Errorf("request %d: unexpected Vary, given = %q", i, got)
+		}
+		var rd io.Reader = w.Body
+		if acceptEncoding == "gzip" {
+			if rd, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatalf("request %d: %v", i, err)
+			}
+		}
+		got, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if string(got) != body {
+			t.Errorf("request %d: unexpected body, given = %.40q", i, got)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A LockoutConfig expresses how failed logins are throttled.
+type LockoutConfig struct {
+	// UserMaxFailures is the number of consecutive failures for one username
+	// that locks it out.
+	UserMaxFailures int
+	// IPMaxFailures is the number of consecutive failures from one client IP
+	// that locks it out, whatever usernames were tried.
+	IPMaxFailures int
+	// BaseDelay is the wait imposed after the first failure. It doubles with
+	// every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutDuration is how long a username or IP stays locked out.
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+	// Username extracts the attempted username. It defaults to the Basic
+	// credentials of the request.
+	Username func(r *http.Request) string
+	// Audit receives an entry for every lockout. It defaults to WriteAuditLog.
+	Audit func(a *model.AuditLog)
+}
+
+// DefaultLockoutConfig returns the configuration used by NewLockout for unset fields.
+func DefaultLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		UserMaxFailures: 5,
+		IPMaxFailures:   20,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+		Username: func(r *http.Request) string {
+			user, _, _ := r.BasicAuth()
+			return user
+		},
+		Audit: WriteAuditLog,
+	}
+}
+
+type attempts struct {
+	failures int
+	// pending counts the attempts being authenticated by their guess, the
+	// credentials tried. They are failures until they pass, but requests
+	// sent together with the same credentials make a single guess.
+	pending     map[string]int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// A Lockout tracks failed authentications per username and per client IP.
+type Lockout struct {
+	cfg LockoutConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*attempts
+	lastSweep time.Time
+}
+
+// NewLockout returns a Lockout, filling unset fields of cfg with DefaultLockoutConfig.
+func NewLockout(cfg LockoutConfig) *Lockout {
+	def := DefaultLockoutConfig()
+	if cfg.UserMaxFailures <= 0 {
+		cfg.UserMaxFailures = def.UserMaxFailures
+	}
+	if cfg.IPMaxFailures <= 0 {
+		cfg.IPMaxFailures = def.IPMaxFailures
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = def.BaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = def.MaxDelay
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = def.LockoutDuration
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.Username == nil {
+		cfg.Username = def.Username
+	}
+	if cfg.Audit == nil {
+		cfg.Audit = def.Audit
+	}
+	return &Lockout{
+		cfg:     cfg,
+		now:     time.Now,
+		entries: make(map[string]*attempts),
+	}
+}
+
+type authPassedKey struct{}
+
+// Wrap returns a middleware that throttles the auth middleware. A request
+// counts as a failed login when auth does not pass it on to the next handler.
+func (l *Lockout) Wrap(auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		passed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, found := r.Context().Value(authPassedKey{}).(*bool); found {
+				*ok = true
+			}
+			h.ServeHTTP(w, r)
+		})
+		guarded := auth(passed)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, ip := l.cfg.Username(r), clientIP(r)
+			// Requests without any credentials only ask for the challenge.
+			attempt := user != "" || r.Header.Get("Authorization") != ""
+			var guess string
+			if attempt {
+				sum := sha256.Sum256([]byte(user + "\x00" + r.Header.Get("Authorization")))
+				guess = string(sum[:])
+			}
+			if wait := l.reserve(user, ip, guess); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				WriteProblem(w, r, http.StatusTooManyRequests, "Too many failed login attempts")
+				return
+			}
+			if !attempt {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+
+			ok := false
+			// settled even when a handler panics, so that no attempt stays pending
+			defer func() { l.finish(r, user, ip, guess, ok) }()
+			guarded.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authPassedKey{}, &ok)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// reserve returns how long the username and IP must wait before trying
+// again. Otherwise the guess, when not empty, is pending until finish. The
+// other guesses pending count as failures, so that concurrent attempts
+// cannot exceed the limits, nor the delays once a guess failed.
+func (l *Lockout) reserve(user, ip, guess string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	keys := l.keys(user, ip)
+	for _, k := range keys {
+		a, ok := l.entries[k.key]
+		if !ok || l.expired(a, now) {
+			continue
+		}
+		if d := a.lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+		others := 0
+		if guess != "" {
+			others = len(a.pending)
+			if a.pending[guess] > 0 {
+				others--
+			}
+		}
+		n := a.failures + others
+		if a.failures > 0 {
+			if d := a.last.Add(l.backoff(n)).Sub(now); d > wait {
+				wait = d
+			}
+		}
+		if others > 0 && n >= k.limit && wait <= 0 {
+			// the other guesses may yet fail up to the limit
+			wait = l.backoff(n)
+		}
+	}
+	if wait > 0 || guess == "" {
+		return wait
+	}
+
+	for _, k := range keys {
+		a, ok := l.entries[k.key]
+		if !ok || l.expired(a, now) {
+			a = &attempts{}
+			l.entries[k.key] = a
+		}
+		if a.pending == nil {
+			a.pending = make(map[string]int)
+		}
+		a.pending[guess]++
+	}
+	return 0
+}
+
+// expired reports whether the failures of a are past Window and no attempt
+// is pending.
+func (l *Lockout) expired(a *attempts, now time.Time) bool {
+	return len(a.pending) == 0 && !a.lockedUntil.After(now) && now.Sub(a.last) > l.cfg.Window
+}
+
+// finish settles the attempt reserved for the username and IP. A passed
+// attempt forgets the failures of the username; failures of the IP decay
+// with Window so that one valid account cannot be used to reset them.
+func (l *Lockout) finish(r *http.Request, user, ip, guess string, passed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, k := range l.keys(user, ip) {
+		a, ok := l.entries[k.key]
+		if !ok {
+			// reserved entries are kept until finished
+			continue
+		}
+		if a.pending[guess]--; a.pending[guess] <= 0 {
+			delete(a.pending, guess)
+		}
+		if passed {
+			if k.key == "user:"+user && len(a.pending) == 0 {
+				delete(l.entries, k.key)
+			}
+			continue
+		}
+		a.failures++
+		a.last = now
+
+		if a.failures >= k.limit && !a.lockedUntil.After(now) {
+			a.lockedUntil = now.Add(l.cfg.LockoutDuration)
+			l.cfg.Audit(&model.AuditLog{
+				Timestamp: now,
+				Event:     "login_lockout",
+				User:      user,
+				RemoteIP:  ip,
+				RequestID: requestID(r),
+				Detail:    fmt.Sprintf("%s locked out for %s after %d failed attempts", k.key, l.cfg.LockoutDuration, a.failures),
+			})
+		}
+	}
+	l.sweep(now)
+}
+
+// backoff returns the wait imposed after the given number of failures.
+func (l *Lockout) backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := l.cfg.BaseDelay
+	for i := 1; i < failures && d < l.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.cfg.MaxDelay {
+		d = l.cfg.MaxDelay
+	}
+	return d
+}
+
+type lockoutKey struct {
+	key   string
+	limit int
+}
+
+func (l *Lockout) keys(user, ip string) []lockoutKey {
+	keys := []lockoutKey{{key: "ip:" + ip, limit: l.cfg.IPMaxFailures}}
+	if user != "" {
+		keys = append(keys, lockoutKey{key: "user:" + user, limit: l.cfg.UserMaxFailures})
+	}
+	return keys
+}
+
+// sweep drops entries that are neither locked, pending nor within Window.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.cfg.Window {
+		return
+	}
+	l.lastSweep = now
+	for key, a := range l.entries {
+		if l.expired(a, now) {
+			delete(l.entries, key)
+		}
+	}
+}
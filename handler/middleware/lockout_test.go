@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestLockout(t *testing.T) {
+	t.Setenv("BASIC_AUTH_USER_ID", "user")
+	t.Setenv("BASIC_AUTH_PASSWORD", "pass")
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var audits []*model.AuditLog
+	l := NewLockout(LockoutConfig{
+		UserMaxFailures: 3,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		LockoutDuration: time.Minute,
+		Audit:           func(a *model.AuditLog) { audits = append(audits, a) },
+	})
+	l.now = func() time.Time { return now }
+	h := l.Wrap(BasicAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(pass string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		r.SetBasicAuth("user", pass)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	steps := []struct {
+		advance    time.Duration
+		pass       string
+		wantStatus int
+		wantRetry  string
+	}{
+		{pass: "wrong", wantStatus: http.StatusUnauthorized},
+		{pass: "pass", wantStatus: http.StatusTooManyRequests, wantRetry: "1"},
+		{advance: time.Second, pass: "wrong", wantStatus: http.StatusUnauthorized},
+		{advance: time.Second, pass: "pass", wantStatus: http.StatusTooManyRequests, wantRetry: "1"},
+		{advance: time.Second, pass: "wrong", wantStatus: http.StatusUnauthorized},
+		{advance: 10 * time.Second, pass: "pass", wantStatus: http.StatusTooManyRequests, wantRetry: "50"},
+		{advance: time.Minute, pass: "pass", wantStatus: http.StatusOK},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		w := do(s.pass)
+		if w.Code != s.wantStatus {
+			t.Fatalf("step %d: unexpected status, given = %d, expected = %d", i, w.Code, s.wantStatus)
+		}
+		if got := w.Header().Get("Retry-After"); got != s.wantRetry {
+			t.Fatalf("step %d: unexpected Retry-After, given = %q, expected = %q", i, got, s.wantRetry)
+		}
+	}
+
+	if len(audits) != 1 || audits[0].Event != "login_lockout" || audits[0].User != "user" {
+		t.Errorf("unexpected audit logs, given = %+v", audits)
+	}
+}
+
+// heldCredentials accepts pass, holding every check until released.
+type heldCredentials struct {
+	pass    string
+	checks  chan string
+	release chan struct{}
+}
+
+func (c *heldCredentials) Verify(user, pass string) bool {
+	c.checks <- pass
+	<-c.release
+	return pass == c.pass
+}
+
+func TestLockoutConcurrent(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLockout(LockoutConfig{
+		UserMaxFailures: 3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutDuration: time.Minute,
+		Audit:           func(a *model.AuditLog) {},
+	})
+	l.now = func() time.Time { return now }
+	creds := &heldCredentials{pass: "pass", checks: make(chan string, 10), release: make(chan struct{})}
+	h := l.Wrap(NewBasicAuth(creds))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// send starts the requests with the passwords together, and returns how
+	// many reached the check, before releasing them.
+	send := func(passes ...string) (checked int, codes []int) {
+		t.Helper()
+		results := make(chan int, len(passes))
+		for _, pass := range passes {
+			pass := pass
+			go func() {
+				r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+				r.SetBasicAuth("user", pass)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				results <- w.Code
+			}()
+		}
+		// the requests turned away answer without waiting for the release
+		for i := 0; i < len(passes); i++ {
+			select {
+			case <-creds.checks:
+				checked++
+			case code := <-results:
+				codes = append(codes, code)
+			}
+		}
+		for i := 0; i < checked; i++ {
+			creds.release <- struct{}{}
+		}
+		for len(codes) < len(passes) {
+			codes = append(codes, <-results)
+		}
+		return checked, codes
+	}
+	count := func(codes []int, code int) int {
+		n := 0
+		for _, c := range codes {
+			if c == code {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the same credentials sent together make one guess
+	if checked, codes := send("pass", "pass", "pass", "pass", "pass"); checked != 5 || count(codes, http.StatusOK) != 5 {
+		t.Fatalf("parallel valid requests: %d checked, statuses %v", checked, codes)
+	}
+
+	// guesses sent together stop at the limit
+	checked, codes := send("a", "b", "c", "d", "e")
+	if checked != 3 || count(codes, http.StatusUnauthorized) != 3 || count(codes, http.StatusTooManyRequests) != 2 {
+		t.Fatalf("parallel guesses: %d checked, statuses %v, want 3 checked", checked, codes)
+	}
+	if wait := l.reserve("user", "192.0.2.1", ""); wait < time.Minute-time.Second {
+		t.Errorf("parallel guesses did not lock the user out, wait = %v", wait)
+	}
+
+	// once a guess failed, guesses sent together are delayed one by one
+	now = now.Add(2 * time.Hour)
+	if checked, _ := send("f"); checked != 1 {
+		t.Fatal("the guess after the window was not checked")
+	}
+	now = now.Add(time.Second)
+	if checked, codes := send("g", "h", "i"); checked != 1 || count(codes, http.StatusTooManyRequests) != 2 {
+		t.Errorf("parallel guesses after a failure: %d checked, statuses %v, want 1 checked", checked, codes)
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/tracing"
+)
+
+// NewRequestLogger returns a middleware that carries base, annotated with the
+// method, route, request ID, trace ID and authenticated user, in the request
+// context.
+func NewRequestLogger(base *logger.Logger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, user := auth.RecordUser(r.Context())
+			id := requestID(r)
+			var traceID string
+			if sc := tracing.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+				traceID = sc.TraceID.String()
+			}
+			l := base.With(
+				"method", r.Method,
+				"route", r.URL.Path,
+				"request_id", func() string { return id },
+				"trace_id", func() string { return traceID },
+				"user", user,
+			)
+			h.ServeHTTP(w, r.WithContext(logger.NewContext(ctx, l)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
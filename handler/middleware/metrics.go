@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/metrics"
+)
+
+// A Metrics records request counts, latencies and in-flight requests per route.
+type Metrics struct {
+	requests *metrics.Vec
+	duration *metrics.HistogramVec
+	inFlight *metrics.Vec
+}
+
+// NewMetrics returns a Metrics whose collectors are registered to reg.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	m := &Metrics{
+		requests: metrics.NewCounterVec("http_requests_total", "Number of HTTP requests.", "route", "method", "status"),
+		duration: metrics.NewHistogramVec("http_request_duration_seconds", "Latency of HTTP requests.", metrics.DefaultBuckets, "route", "method"),
+		inFlight: metrics.NewGaugeVec("http_requests_in_flight", "Number of HTTP requests being served.", "route"),
+	}
+	reg.MustRegister(m.requests, m.duration, m.inFlight)
+	return m
+}
+
+// Route returns a middleware recording the requests of route. route should be
+// the registered pattern rather than the request path to bound cardinality.
+func (m *Metrics) Route(route string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m.inFlight.Inc(route)
+			defer m.inFlight.Dec(route)
+
+			rw := newResponseWriter(w)
+			defer func() {
+				method := metricMethod(r.Method)
+				m.duration.Observe(time.Since(start).Seconds(), route, method)
+				m.requests.Inc(route, method, strconv.Itoa(rw.status))
+			}()
+
+			h.ServeHTTP(rw, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// metricMethod folds unknown methods into one label value.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A PanicReporter forwards recovered panics to an error tracker.
+type PanicReporter interface {
+	Report(ctx context.Context, p *model.PanicReport) error
+}
+
+// A SentryReporter sends panics as events to the store endpoint of Sentry or
+// any service speaking its protocol.
+type SentryReporter struct {
+	url         string
+	key         string
+	environment string
+	client      *http.Client
+}
+
+// NewSentryReporter returns a SentryReporter for dsn, written as
+// "https://<key>@<host>/<project>".
+func NewSentryReporter(dsn, environment string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, errors.New("middleware: sentry DSN needs a key and a project")
+	}
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	return &SentryReporter{
+		url:         fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:         u.User.Username(),
+		environment: environment,
+		client:      &http.Client{},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Exception   sentryExceptions  `json:"exception"`
+	Request     sentryRequest     `json:"request"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type sentryUser struct {
+	ID string `json:"id"`
+}
+
+// Report implements PanicReporter interface.
+func (s *SentryReporter) Report(ctx context.Context, p *model.PanicReport) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	event := &sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   p.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		Level:       "fatal",
+		Platform:    "go",
+		Environment: s.environment,
+		Message:     "panic: " + p.Value,
+		Exception:   sentryExceptions{Values: []sentryException{{Type: "panic", Value: p.Value}}},
+		Request:     sentryRequest{Method: p.Method, URL: p.URL},
+		Tags:        map[string]string{},
+		Extra:       map[string]string{"stack": p.Stack},
+	}
+	if p.User != "" {
+		event.User = &sentryUser{ID: p.User}
+	}
+	if p.RequestID != "" {
+		event.Tags["request_id"] = p.RequestID
+	}
+	if p.TraceID != "" {
+		event.Tags["trace_id"] = p.TraceID
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=go-stations/1.0, sentry_key="+s.key)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("middleware: sentry responded %s", res.Status)
+	}
+	return nil
+}
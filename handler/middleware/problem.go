@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// WriteProblem answers r with an application/problem+json body.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	problem := &model.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+	problem.RequestID, _ = RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		logger.FromContext(r.Context()).Error("failed to encode problem", "err", err)
+	}
+}
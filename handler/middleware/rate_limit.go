@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+)
+
+// A RateLimit expresses a token bucket refilled with Rate tokens per second
+// that holds at most Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses limits written as "<count>/<unit>[:<burst>]" where
+// unit is s, m or h, e.g. "10/s:20" or "300/m". Burst defaults to count.
+func ParseRateLimit(s string) (RateLimit, error) {
+	spec, burst := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		spec, burst = s[:i], s[i+1:]
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("middleware: invalid rate limit %q", s)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return RateLimit{}, fmt.Errorf("middleware: invalid rate limit %q", s)
+	}
+	var per time.Duration
+	switch parts[1] {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return RateLimit{}, fmt.Errorf("middleware: invalid rate limit unit in %q", s)
+	}
+
+	limit := RateLimit{Rate: float64(count) / per.Seconds(), Burst: count}
+	if burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return RateLimit{}, fmt.Errorf("middleware: invalid rate limit burst in %q", s)
+		}
+	}
+	return limit, nil
+}
+
+// RateLimitKey identifies the client a request is accounted to: the
+// authenticated user, else the bearer API token, else the client IP. Limiters
+// keyed with it go after authentication to account users.
+func RateLimitKey(r *http.Request) string {
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		return "user:" + user
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		// Only a digest is kept in memory.
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(h, "Bearer ")))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + clientIP(r)
+}
+
+// ClientIPKey accounts requests to the client IP resolved by RealIP alone,
+// so that requests failing authentication are limited too.
+func ClientIPKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A RateLimiter throttles requests with one token bucket per client.
+// Create one per route to configure routes independently.
+type RateLimiter struct {
+	limit RateLimit
+	key   func(r *http.Request) string
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a RateLimiter keyed with RateLimitKey.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return NewRateLimiterByKey(limit, RateLimitKey)
+}
+
+// NewRateLimiterByKey returns a RateLimiter accounting requests to the
+// clients key identifies, such as ClientIPKey.
+func NewRateLimiterByKey(limit RateLimit, key func(r *http.Request) string) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		key:     key,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Handler is the middleware, usable as an alice.Constructor.
+func (l *RateLimiter) Handler(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, reset := l.take(l.key(r))
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", l.limit.Burst, int(math.Ceil(float64(l.limit.Burst)/l.limit.Rate))))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			WriteProblem(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Allow consumes a token for the client of r outside of Handler, such as for
+// each command sent over a WebSocket connection. When denied, it returns the
+// time until the next token.
+func (l *RateLimiter) Allow(r *http.Request) (bool, time.Duration) {
+	ok, _, reset := l.take(l.key(r))
+	if ok {
+		return true, 0
+	}
+	return false, reset
+}
+
+// take consumes a token for key. It returns whether one was available, the
+// tokens left, and the time until the next token (when denied) or until the
+// bucket is full again (when allowed).
+func (l *RateLimiter) take(key string) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	burst := float64(l.limit.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, l.refill(1 - b.tokens)
+	}
+	b.tokens--
+	return true, int(b.tokens), l.refill(burst - b.tokens)
+}
+
+func (l *RateLimiter) refill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have been refilled completely.
+func (l *RateLimiter) sweep(now time.Time) {
+	full := l.refill(float64(l.limit.Burst))
+	if now.Sub(l.lastSweep) < full || now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		spec    string
+		want    RateLimit
+		wantErr bool
+	}{
+		"Per second":     {spec: "10/s", want: RateLimit{Rate: 10, Burst: 10}},
+		"Per minute":     {spec: "300/m", want: RateLimit{Rate: 5, Burst: 300}},
+		"Per hour":       {spec: "3600/h:10", want: RateLimit{Rate: 1, Burst: 10}},
+		"Burst":          {spec: "10/s:20", want: RateLimit{Rate: 10, Burst: 20}},
+		"No unit":        {spec: "10", wantErr: true},
+		"Unknown unit":   {spec: "10/d", wantErr: true},
+		"Zero count":     {spec: "0/s", wantErr: true},
+		"Negative count": {spec: "-1/s", wantErr: true},
+		"Zero burst":     {spec: "10/s:0", wantErr: true},
+		"Bad burst":      {spec: "10/s:x", wantErr: true},
+		"Empty":          {spec: "", wantErr: true},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseRateLimit(tc.spec)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("ParseRateLimit(%q) = %+v, want %+v", tc.spec, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		r = r.WithContext(auth.ContextWithUser(r.Context(), user))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	steps := []struct {
+		advance       time.Duration
+		user          string
+		wantStatus    int
+		wantRemaining string
+		wantRetry     string
+	}{
+		// the burst is spent
+		{user: "alice", wantStatus: http.StatusOK, wantRemaining: "2"},
+		{user: "alice", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{user: "alice", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{user: "alice", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetry: "1"},
+		// others have their own bucket
+		{user: "bob", wantStatus: http.StatusOK, wantRemaining: "2"},
+		// a token comes back every half second
+		{advance: 500 * time.Millisecond, user: "alice", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{user: "alice", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetry: "1"},
+		// refilled up to the burst only
+		{advance: time.Hour, user: "alice", wantStatus: http.StatusOK, wantRemaining: "2"},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		w := do(s.user)
+		if w.Code != s.wantStatus {
+			t.Errorf("step %d: status = %d, want %d", i, w.Code, s.wantStatus)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != s.wantRemaining {
+			t.Errorf("step %d: RateLimit-Remaining = %q, want %q", i, got, s.wantRemaining)
+		}
+		if got := w.Header().Get("Retry-After"); got != s.wantRetry {
+			t.Errorf("step %d: Retry-After = %q, want %q", i, got, s.wantRetry)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "3" {
+			t.Errorf("step %d: RateLimit-Limit = %q, want 3", i, got)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		user       string
+		authHeader string
+		clientIP   string
+		want       string
+		wantIP     string
+	}{
+		"User":        {user: "alice", authHeader: "Bearer t", want: "user:alice", wantIP: "ip:192.0.2.1"},
+		"Token":       {authHeader: "Bearer t", want: "token:e3b98a4da31a127d", wantIP: "ip:192.0.2.1"},
+		"Basic":       {authHeader: "Basic dTpw", want: "ip:192.0.2.1", wantIP: "ip:192.0.2.1"},
+		"Resolved IP": {clientIP: "203.0.113.9", want: "ip:203.0.113.9", wantIP: "ip:203.0.113.9"},
+		"Anonymous":   {want: "ip:192.0.2.1", wantIP: "ip:192.0.2.1"},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if tc.authHeader != "" {
+				r.Header.Set("Authorization", tc.authHeader)
+			}
+			if tc.user != "" {
+				r = r.WithContext(auth.ContextWithUser(r.Context(), tc.user))
+			}
+			if tc.clientIP != "" {
+				r = r.WithContext(ContextWithClientIP(r.Context(), tc.clientIP))
+			}
+			if got := RateLimitKey(r); got != tc.want {
+				t.Errorf("RateLimitKey = %q, want %q", got, tc.want)
+			}
+			if got := ClientIPKey(r); got != tc.wantIP {
+				t.Errorf("ClientIPKey = %q, want %q", got, tc.wantIP)
+			}
+		})
+	}
+}
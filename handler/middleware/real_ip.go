@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ContextWithClientIP returns a copy of parent that carries the client IP.
+func ContextWithClientIP(parent context.Context, ip string) context.Context {
+	return context.WithValue(parent, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP resolved by RealIP.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(string)
+	return ip, ok
+}
+
+// clientIP returns the IP address resolved by RealIP, or the address of the
+// peer that sent r when RealIP is not in the chain.
+func clientIP(r *http.Request) string {
+	if ip, ok := ClientIPFromContext(r.Context()); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewRealIP returns a middleware that resolves the client IP of requests.
+// X-Forwarded-For is only honoured when the peer is one of the trusted
+// proxies, given as IP addresses or CIDR ranges. The rightmost address not
+// belonging to a trusted proxy is taken as the client. The entry "unix"
+// trusts peers connected over Unix domain sockets, such as a sidecar proxy.
+func NewRealIP(trustedProxies []string) (func(http.Handler) http.Handler, error) {
+	var (
+		nets      []*net.IPNet
+		trustUnix bool
+	)
+	for _, p := range trustedProxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if p == "unix" {
+			trustUnix = true
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("middleware: invalid trusted proxy %q: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			// peers on Unix sockets have no IP address
+			if trusted(ip) || (trustUnix && net.ParseIP(ip) == nil) {
+				hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := strings.TrimSpace(hops[i])
+					if hop == "" {
+						continue
+					}
+					ip = hop
+					if !trusted(hop) {
+						break
+					}
+				}
+			}
+			r = r.WithContext(ContextWithClientIP(r.Context(), ip))
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
@@ -0,0 +1,112 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestRealIP(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		trusted      []string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		"No proxies": {
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "192.0.2.1",
+		},
+		"Untrusted peer": {
+			trusted:      []string{"10.0.0.1"},
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "192.0.2.1",
+		},
+		"Trusted peer": {
+			trusted:      []string{"10.0.0.1"},
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		"Rightmost untrusted hop": {
+			trusted:      []string{"10.0.0.0/8"},
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.7, 203.0.113.9, 10.0.0.2"},
+			want:         "203.0.113.9",
+		},
+		"Several headers": {
+			trusted:      []string{"10.0.0.0/8"},
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"198.51.100.7", "203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		"Only trusted hops": {
+			trusted:      []string{"10.0.0.0/8"},
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"10.0.0.3, 10.0.0.2"},
+			want:         "10.0.0.3",
+		},
+		"No header": {
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		"IPv6 peer": {
+			trusted:      []string{"2001:db8::1"},
+			remoteAddr:   "[2001:db8::1]:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		"Unix peer": {
+			trusted:      []string{"unix"},
+			remoteAddr:   "@",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		"Unix peer untrusted": {
+			trusted:      []string{"10.0.0.0/8"},
+			remoteAddr:   "@",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "@",
+		},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			realIP, err := middleware.NewRealIP(tc.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			h := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = middleware.ClientIPFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tc.want {
+				t.Errorf("client IP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRealIPInvalidProxy(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []string{"proxy.example.com", "10.0.0.0/33", "10.0.0.256"} {
+		if _, err := middleware.NewRealIP([]string{p}); err == nil {
+			t.Errorf("NewRealIP(%q) succeeded, want error", p)
+		}
+	}
+}
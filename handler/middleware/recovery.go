@@ -1,21 +1,86 @@
 package middleware
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/tracing"
 )
 
-func Recovery(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Println("recoverd")
-				log.Println(err)
-			} else {
-				log.Println("not recoverd")
-			}
-		}()
-		h.ServeHTTP(w, r)
+// reportTimeout bounds the time a reporter may take with one panic.
+const reportTimeout = 10 * time.Second
+
+// NewRecovery returns a middleware that turns a panic into a 500 problem
+// response, unless the response has already started, and logs it with the
+// stack. The panic is also counted in reg and sent to reporter in the
+// background; either may be nil.
+func NewRecovery(reporter PanicReporter, reg *metrics.Registry) func(http.Handler) http.Handler {
+	panics := metrics.NewCounterVec("http_panics_total", "Number of panics recovered while serving HTTP requests.")
+	if reg != nil {
+		reg.MustRegister(panics)
+	}
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, user := auth.RecordUser(r.Context())
+			rw := newResponseWriter(w)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					// the server aborts the response quietly
+					panic(v)
+				}
+				stack := string(debug.Stack())
+				panics.Inc()
+				logger.FromContext(ctx).Error("recovered from panic", "panic", fmt.Sprint(v), "stack", stack)
+				tracing.SpanFromContext(ctx).SetError(fmt.Errorf("panic: %v", v))
+
+				if !rw.wroteHeader {
+					WriteProblem(rw, r, http.StatusInternalServerError, "")
+				}
+
+				if reporter == nil {
+					return
+				}
+				report := &model.PanicReport{
+					Timestamp: time.Now(),
+					Value:     fmt.Sprint(v),
+					Stack:     stack,
+					Method:    r.Method,
+					URL:       r.URL.String(),
+					User:      user(),
+					RequestID: requestID(r),
+				}
+				if sc := tracing.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+					report.TraceID = sc.TraceID.String()
+				}
+				l := logger.FromContext(ctx)
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+					defer cancel()
+					if err := reporter.Report(ctx, report); err != nil {
+						l.Error("failed to report panic", "err", err)
+					}
+				}()
+			}()
+			h.ServeHTTP(rw, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
+}
+
+var defaultRecovery = NewRecovery(nil, nil)
+
+// Recovery answers panics with 500 without reporting them anywhere but the log.
+func Recovery(h http.Handler) http.Handler {
+	return defaultRecovery(h)
 }
@@ -0,0 +1,88 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestRecovery(t *testing.T) {
+	t.Parallel()
+
+	type event struct {
+		Message string            `json:"message"`
+		Tags    map[string]string `json:"tags"`
+		Extra   map[string]string `json:"extra"`
+	}
+	events := make(chan event, 1)
+	sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("unexpected report %s, auth = %s", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+		}
+		var e event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error("failed to decode event, err =", err)
+		}
+		events <- e
+	}))
+	t.Cleanup(sentry.Close)
+
+	reporter, err := middleware.NewSentryReporter(strings.Replace(sentry.URL, "://", "://public@", 1)+"/42", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := metrics.NewRegistry()
+	recovery := middleware.NewRecovery(reporter, reg)
+
+	h := middleware.RequestID(recovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/do-panic", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	var problem model.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil || problem.RequestID != "req-1" {
+		t.Errorf("problem = %+v, err = %v", problem, err)
+	}
+
+	select {
+	case e := <-events:
+		if e.Message != "panic: boom" || e.Tags["request_id"] != "req-1" || !strings.Contains(e.Extra["stack"], "recovery_test.go") {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic was not reported")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partial", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status after headers were sent = %d, want 202", rec.Code)
+	}
+	<-events
+
+	var buf bytes.Buffer
+	if err := reg.Gather(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "http_panics_total 2\n") {
+		t.Errorf("panic metric not counted:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+	"github.com/TechBowl-japan/go-stations/tracing"
+)
+
+// RequestIDHeader is the header the request ID is read from and echoed in.
+const RequestIDHeader = "X-Request-ID"
+
+func ContextWithRequestID(parent context.Context, id string) context.Context {
+	return requestid.NewContext(parent, id)
+}
+
+func RequestIDFromContext(ctx context.Context) (string, error) {
+	id, ok := requestid.FromContext(ctx)
+	if !ok {
+		return "", errors.New("request ID not found")
+	}
+	return id, nil
+}
+
+// requestID returns the request ID of r, or an empty string.
+func requestID(r *http.Request) string {
+	id, _ := requestid.FromContext(r.Context())
+	return id
+}
+
+// RequestID accepts a valid X-Request-ID from the client or generates one,
+// stores it in the context and echoes it in the response headers.
+func RequestID(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		tracing.SpanFromContext(r.Context()).SetAttribute("request_id", id)
+		r = r.WithContext(ContextWithRequestID(r.Context(), id))
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
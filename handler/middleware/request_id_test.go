@@ -0,0 +1,53 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/requestid"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		header   string
+		wantSame bool
+	}{
+		"Given":    {header: "client-id.1", wantSame: true},
+		"Missing":  {},
+		"Invalid":  {header: "a */ DROP TABLE todos; /*"},
+		"Too long": {header: string(make([]byte, requestid.MaxLength+1))},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var got string
+			h := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				if got, err = middleware.RequestIDFromContext(r.Context()); err != nil {
+					t.Error(err)
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			if tc.header != "" {
+				r.Header.Set(middleware.RequestIDHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if echoed := w.Header().Get(middleware.RequestIDHeader); echoed != got {
+				t.Errorf("echoed %q, want the ID of the context %q", echoed, got)
+			}
+			if (got == tc.header) != tc.wantSame {
+				t.Errorf("request ID = %q, want the given one %t", got, tc.wantSame)
+			}
+			if !requestid.Valid(got) {
+				t.Errorf("request ID %q is not valid", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+)
+
+// NewRequireUsers returns a middleware that only lets the listed users
+// through. It must follow an authentication middleware. Nobody is allowed
+// when users is empty.
+func NewRequireUsers(users []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(users))
+	for _, u := range users {
+		if u != "" {
+			allowed[u] = true
+		}
+	}
+
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.UserFromContext(r.Context())
+			if !ok || !allowed[user] {
+				WriteProblem(w, r, http.StatusForbidden, "This endpoint is restricted to administrators")
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,44 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestRequireUsers(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := map[string]struct {
+		users     []string
+		user      string
+		anonymous bool
+		want      int
+	}{
+		"Listed":           {users: []string{"admin"}, user: "admin", want: http.StatusOK},
+		"One of several":   {users: []string{"admin", "ops"}, user: "ops", want: http.StatusOK},
+		"Not listed":       {users: []string{"admin"}, user: "alice", want: http.StatusForbidden},
+		"Unauthenticated":  {users: []string{"admin"}, anonymous: true, want: http.StatusForbidden},
+		"Empty list":       {users: nil, user: "alice", want: http.StatusForbidden},
+		"Empty names only": {users: []string{""}, user: "", want: http.StatusForbidden},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodPut, "/admin/log-level", nil)
+			if !tc.anonymous {
+				req = req.WithContext(auth.ContextWithUser(req.Context(), tc.user))
+			}
+			rec := httptest.NewRecorder()
+			middleware.NewRequireUsers(tc.users)(ok).ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
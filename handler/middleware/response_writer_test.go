@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackRecorder is a ResponseRecorder whose connection can be taken over.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		handler     func(w http.ResponseWriter)
+		wantStatus  int
+		wantBytes   int64
+		wantWritten bool
+	}{
+		"Nothing written": {
+			handler:    func(w http.ResponseWriter) {},
+			wantStatus: http.StatusOK,
+		},
+		"Implicit 200": {
+			handler: func(w http.ResponseWriter) {
+				w.Write([]byte("hello, "))
+				w.Write([]byte("world"))
+			},
+			wantStatus:  http.StatusOK,
+			wantBytes:   12,
+			wantWritten: true,
+		},
+		"Explicit status": {
+			handler: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("{}"))
+			},
+			wantStatus:  http.StatusCreated,
+			wantBytes:   2,
+			wantWritten: true,
+		},
+		"First status wins": {
+			handler: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus:  http.StatusNotFound,
+			wantWritten: true,
+		},
+		"Status after body": {
+			handler: func(w http.ResponseWriter) {
+				w.Write([]byte("x"))
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus:  http.StatusOK,
+			wantBytes:   1,
+			wantWritten: true,
+		},
+		"Flush": {
+			handler: func(w http.ResponseWriter) {
+				w.(http.Flusher).Flush()
+			},
+			wantStatus:  http.StatusOK,
+			wantWritten: true,
+		},
+		"Hijack": {
+			handler: func(w http.ResponseWriter) {
+				w.(http.Hijacker).Hijack()
+			},
+			wantStatus:  http.StatusSwitchingProtocols,
+			wantWritten: true,
+		},
+	}
+	for name, tc := range cases {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+			w := newResponseWriter(rec)
+			tc.handler(w)
+
+			if w.status != tc.wantStatus || w.bytes != tc.wantBytes || w.wroteHeader != tc.wantWritten {
+				t.Errorf("recorded %d, %d bytes, written %t, want %d, %d bytes, written %t",
+					w.status, w.bytes, w.wroteHeader, tc.wantStatus, tc.wantBytes, tc.wantWritten)
+			}
+			if int64(rec.Body.Len()) != w.bytes {
+				t.Errorf("passed %d bytes through, recorded %d", rec.Body.Len(), w.bytes)
+			}
+			if name == "Flush" && !rec.Flushed {
+				t.Error("Flush was not passed through")
+			}
+			if name == "Hijack" && !rec.hijacked {
+				t.Error("Hijack was not passed through")
+			}
+		})
+	}
+
+	// writers that cannot be hijacked say so
+	w := newResponseWriter(httptest.NewRecorder())
+	if _, _, err := w.Hijack(); err == nil {
+		t.Error("Hijack of a recorder succeeded")
+	}
+	if w.wroteHeader {
+		t.Error("a failed Hijack counted as a response")
+	}
+	if w.Unwrap() == nil {
+		t.Error("Unwrap returned nil")
+	}
+}
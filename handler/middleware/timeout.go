@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// NewTimeout returns a middleware that cancels the request context after d,
+// so that queries run with it are aborted. A handler that gave up without
+// writing a response is answered 503. A zero d disables the timeout.
+func NewTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if d <= 0 {
+			return h
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			rw := newResponseWriter(w)
+			h.ServeHTTP(rw, r.WithContext(ctx))
+			if ctx.Err() == context.DeadlineExceeded && !rw.wroteHeader {
+				WriteProblem(rw, r, http.StatusServiceUnavailable, "the request took too long")
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
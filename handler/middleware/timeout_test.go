@@ -0,0 +1,34 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+
+	canceled := make(chan struct{})
+	h := middleware.NewTimeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(time.Second):
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todos", nil))
+	select {
+	case <-canceled:
+	default:
+		t.Error("request context was not canceled")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/tracing"
+)
+
+// A Tracing starts a server span for every request, continuing the trace
+// propagated in the traceparent header.
+type Tracing struct {
+	tracer *tracing.Tracer
+}
+
+// NewTracing returns a Tracing recording spans with tracer.
+func NewTracing(tracer *tracing.Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+// Route returns a middleware tracing the requests of route. The span is named
+// after the registered pattern rather than the request path.
+func (t *Tracing) Route(route string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if sc, ok := tracing.Extract(r.Header); ok {
+				ctx = tracing.ContextWithRemote(ctx, sc)
+			}
+			ctx, span := t.tracer.Start(ctx, r.Method+" "+route, tracing.SpanKindServer)
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.RequestURI())
+			span.SetAttribute("http.user_agent", r.UserAgent())
+
+			ctx, user := auth.RecordUser(ctx)
+			rw := newResponseWriter(w)
+			defer func() {
+				span.SetAttribute("http.status_code", rw.status)
+				if u := user(); u != "" {
+					span.SetAttribute("enduser.id", u)
+				}
+				var err error
+				if rw.status >= http.StatusInternalServerError {
+					err = httpError(rw.status)
+				}
+				span.End(err)
+			}()
+
+			h.ServeHTTP(rw, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// httpError is the error recorded on spans of failed responses.
+type httpError int
+
+func (e httpError) Error() string {
+	return http.StatusText(int(e))
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// Media types the TODOs can be read as.
+const (
+	mediaJSON    = "application/json"
+	mediaNDJSON  = "application/x-ndjson"
+	mediaMsgpack = "application/msgpack"
+	mediaCSV     = "text/csv"
+)
+
+// todoMediaTypes are offered to clients of GET /todos, JSON first as the
+// default.
+var todoMediaTypes = []string{mediaJSON, mediaNDJSON, mediaMsgpack, mediaCSV}
+
+// mediaAliases maps the other names clients use to the offered types.
+var mediaAliases = map[string]string{
+	"application/x-msgpack": mediaMsgpack,
+	"application/ndjson":    mediaNDJSON,
+	"application/jsonl":     mediaNDJSON,
+}
+
+// negotiate returns the offer preferred by the Accept header value, or false
+// when none is acceptable. Offers earlier in the list win ties, and an empty
+// header accepts the first one.
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if alias, ok := mediaAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			specificity := matchMedia(mediaType, offer)
+			if specificity < 0 {
+				continue
+			}
+			if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = offer, q, specificity
+			}
+			break
+		}
+	}
+	return best, best != ""
+}
+
+// matchMedia returns how specifically the media range matches offer: 2 for
+// the same type, 1 for type/*, 0 for */*, or -1 when it does not match.
+func matchMedia(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// A todoEncoder writes TODOs one at a time, for streaming.
+type todoEncoder interface {
+	Encode(todo *model.TODO) error
+	// Flush writes what is buffered to the underlying writer.
+	Flush() error
+}
+
+// newTODOEncoder returns the todoEncoder of the streamed mediaType.
+func newTODOEncoder(w io.Writer, mediaType string) (todoEncoder, error) {
+	if mediaType == mediaCSV {
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"id", "subject", "description", "owner", "list_id", "created_at", "updated_at"})
+		return &csvTODOEncoder{w: cw}, err
+	}
+	return &ndjsonTODOEncoder{enc: json.NewEncoder(w)}, nil
+}
+
+// An ndjsonTODOEncoder writes a TODO as JSON per line.
+type ndjsonTODOEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonTODOEncoder) Encode(todo *model.TODO) error {
+	return e.enc.Encode(todo)
+}
+
+func (e *ndjsonTODOEncoder) Flush() error {
+	return nil
+}
+
+// A csvTODOEncoder writes a TODO per record, after a header record.
+type csvTODOEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvTODOEncoder) Encode(todo *model.TODO) error {
+	return e.w.Write([]string{
+		strconv.FormatInt(todo.ID, 10),
+		csvText(todo.Subject),
+		csvText(todo.Description),
+		csvText(todo.Owner),
+		strconv.FormatInt(todo.ListID, 10),
+		todo.CreatedAt.Format(time.RFC3339),
+		todo.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+func (e *csvTODOEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText quotes the text of a cell that spreadsheets would otherwise run as
+// a formula, the way they quote text typed in. Some spreadsheets skip a
+// leading tab or carriage return before looking for the formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
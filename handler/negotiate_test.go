@@ -0,0 +1,34 @@
+package handler
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		"No header":          {accept: "", want: mediaJSON, ok: true},
+		"Any":                {accept: "*/*", want: mediaJSON, ok: true},
+		"Exact":              {accept: "text/csv", want: mediaCSV, ok: true},
+		"Alias":              {accept: "application/x-msgpack", want: mediaMsgpack, ok: true},
+		"Weighted":           {accept: "application/json;q=0.5, application/x-ndjson", want: mediaNDJSON, ok: true},
+		"Specific over any":  {accept: "*/*, text/csv", want: mediaCSV, ok: true},
+		"Type wildcard":      {accept: "text/*", want: mediaCSV, ok: true},
+		"Browser":            {accept: "text/html,application/xhtml+xml,*/*;q=0.8", want: mediaJSON, ok: true},
+		"Excluded":           {accept: "application/json;q=0", ok: false},
+		"Nothing acceptable": {accept: "image/png", ok: false},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := negotiate(tc.accept, todoMediaTypes)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("negotiate(%q) = %q, %v, want %q, %v", tc.accept, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
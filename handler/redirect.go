@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+)
+
+// An HTTPSRedirectHandler redirects plain HTTP requests to the same URL on
+// the HTTPS listener.
+type HTTPSRedirectHandler struct {
+	port string
+}
+
+// NewHTTPSRedirectHandler returns HTTPSRedirectHandler based http.Handler
+// redirecting to httpsAddr, the address of the HTTPS listener such as ":8443".
+// An empty httpsAddr redirects to the default port.
+func NewHTTPSRedirectHandler(httpsAddr string) *HTTPSRedirectHandler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return &HTTPSRedirectHandler{port: port}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *HTTPSRedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if h.port != "" && h.port != "443" {
+		host = net.JoinHostPort(host, h.port)
+	}
+	u := *r.URL
+	u.Scheme = "https"
+	u.Host = host
+	// 308 keeps the method and body of non-GET requests
+	http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,43 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+)
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		httpsAddr string
+		method    string
+		target    string
+		want      string
+	}{
+		"Default port":     {httpsAddr: ":443", method: http.MethodGet, target: "http://example.com/todos", want: "https://example.com/todos"},
+		"Unknown port":     {httpsAddr: "", method: http.MethodGet, target: "http://example.com/todos", want: "https://example.com/todos"},
+		"Other port":       {httpsAddr: "[::]:8443", method: http.MethodGet, target: "http://example.com/todos", want: "https://example.com:8443/todos"},
+		"Host with port":   {httpsAddr: "0.0.0.0:8443", method: http.MethodGet, target: "http://example.com:8080/todos", want: "https://example.com:8443/todos"},
+		"Query":            {httpsAddr: ":443", method: http.MethodGet, target: "http://example.com/todos?size=1&prev_id=2", want: "https://example.com/todos?size=1&prev_id=2"},
+		"IPv6 host":        {httpsAddr: ":8443", method: http.MethodGet, target: "http://[::1]:8080/", want: "https://[::1]:8443/"},
+		"Method preserved": {httpsAddr: ":443", method: http.MethodPost, target: "http://example.com/todos", want: "https://example.com/todos"},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			rec := httptest.NewRecorder()
+			handler.NewHTTPSRedirectHandler(tc.httpsAddr).ServeHTTP(rec, req)
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusPermanentRedirect)
+			}
+			if got := rec.Header().Get("Location"); got != tc.want {
+				t.Errorf("Location = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
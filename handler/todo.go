@@ -3,23 +3,26 @@ package handler
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"math"
 	"net/http"
-	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/logger"
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 // A TODOHandler implements handling REST endpoints.
 type TODOHandler struct {
-	svc *service.TODOService
+	svc service.TODOStore
 }
 
 // NewTODOHandler returns TODOHandler based http.Handler.
-func NewTODOHandler(svc *service.TODOService) *TODOHandler {
+func NewTODOHandler(svc service.TODOStore) *TODOHandler {
 	return &TODOHandler{
 		svc: svc,
 	}
@@ -34,7 +37,7 @@ func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			prev_id, err := strconv.ParseInt(r.URL.Query().Get("prev_id"), 10, 64)
 			if err != nil {
-				log.Println(err)
+				logger.FromContext(r.Context()).Warn("invalid prev_id", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
@@ -46,56 +49,98 @@ func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
 			if err != nil {
-				log.Println(err)
+				logger.FromContext(r.Context()).Warn("invalid size", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 			req.Size = size
 		}
-		todos, err := h.svc.ReadTODO(r.Context(), req.PrevID, req.Size)
+
+		if len(r.URL.Query().Get("list_id")) != 0 {
+			listID, err := strconv.ParseInt(r.URL.Query().Get("list_id"), 10, 64)
+			if err != nil {
+				logger.FromContext(r.Context()).Warn("invalid list_id", "err", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			req.ListID = listID
+		}
+
+		w.Header().Add("Vary", "Accept")
+		mediaType, ok := negotiate(r.Header.Get("Accept"), todoMediaTypes)
+		if !ok {
+			middleware.WriteProblem(w, r, http.StatusNotAcceptable, "Acceptable types are "+strings.Join(todoMediaTypes, ", "))
+			return
+		}
+		// read before the TODOs, so that a change in between makes the client
+		// fetch them again rather than keep them under a newer ETag
+		versions, err := h.svc.ReadListTODOVersions(r.Context(), req.ListID, req.PrevID, req.Size)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.FromContext(r.Context()).Error("failed to read todo versions", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		deletedAt, err := h.svc.LastTODODeletion(r.Context())
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read todo deletions", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		if checkNotModified(w, r, todoETag(versions, mediaType), lastModified(versions, deletedAt)) {
+			return
+		}
+		if mediaType == mediaNDJSON || mediaType == mediaCSV {
+			h.streamTODOs(w, r, req, mediaType)
+			return
+		}
+		todos, err := h.svc.ReadListTODO(r.Context(), req.ListID, req.PrevID, req.Size)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read todos", "err", err)
+			w.WriteHeader(statusFromError(err))
 			return
 		}
 		response := &model.ReadTODOResponse{TODOs: todos}
+		w.Header().Set("Content-Type", mediaType)
+		if mediaType == mediaMsgpack {
+			enc := msgpack.NewEncoder(w)
+			// the fields are named as in JSON
+			enc.SetCustomStructTag("json")
+			if err := enc.Encode(response); err != nil {
+				logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
+			}
+			return
+		}
 		encoder := json.NewEncoder(w)
 		if err := encoder.Encode(response); err != nil {
-			log.Println(err)
+			logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	case http.MethodPost:
-		decoder := json.NewDecoder(r.Body)
 		req := &model.CreateTODORequest{}
-		if err := decoder.Decode(req); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if !decodeRequest(w, r, req) {
 			return
 		}
 		if req.Subject == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		todo, err := h.svc.CreateTODO(r.Context(), req.Subject, req.Description)
+		todo, err := h.svc.CreateListTODO(r.Context(), req.ListID, req.Subject, req.Description)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.FromContext(r.Context()).Error("failed to create todo", "err", err)
+			w.WriteHeader(statusFromError(err))
 			return
 		}
 		response := &model.CreateTODOResponse{TODO: *todo}
 		encoder := json.NewEncoder(w)
 		if err := encoder.Encode(response); err != nil {
-			log.Println(err)
+			logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	case http.MethodPut:
-		decoder := json.NewDecoder(r.Body)
 		req := &model.UpdateTODORequest{}
-		if err := decoder.Decode(req); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if !decodeRequest(w, r, req) {
 			return
 		}
 		if req.ID == 0 || req.Subject == "" {
@@ -104,27 +149,20 @@ func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		todo, err := h.svc.UpdateTODO(r.Context(), req.ID, req.Subject, req.Description)
 		if err != nil {
-			log.Println(err)
-			if reflect.TypeOf(err) == reflect.TypeOf(&model.ErrNotFound{}) {
-				w.WriteHeader(http.StatusNotFound)
-			} else {
-				w.WriteHeader(http.StatusBadRequest)
-			}
+			logger.FromContext(r.Context()).Error("failed to update todo", "err", err)
+			w.WriteHeader(statusFromError(err))
 			return
 		}
 		response := &model.UpdateTODOResponse{TODO: *todo}
 		encoder := json.NewEncoder(w)
 		if err := encoder.Encode(response); err != nil {
-			log.Println(err)
+			logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	case http.MethodDelete:
-		decoder := json.NewDecoder(r.Body)
 		req := &model.DeleteTODORequest{}
-		if err := decoder.Decode(req); err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if !decodeRequest(w, r, req) {
 			return
 		}
 		if len(req.IDs) == 0 {
@@ -132,18 +170,14 @@ func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err := h.svc.DeleteTODO(r.Context(), req.IDs); err != nil {
-			log.Println(err)
-			if reflect.TypeOf(err) == reflect.TypeOf(&model.ErrNotFound{}) {
-				w.WriteHeader(http.StatusNotFound)
-			} else {
-				w.WriteHeader(http.StatusBadRequest)
-			}
+			logger.FromContext(r.Context()).Error("failed to delete todos", "err", err)
+			w.WriteHeader(statusFromError(err))
 			return
 		}
 		response := &model.DeleteTODOResponse{}
 		encoder := json.NewEncoder(w)
 		if err := encoder.Encode(response); err != nil {
-			log.Println(err)
+			logger.FromContext(r.Context()).Error("failed to encode response", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -152,6 +186,108 @@ func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// A TODOItemHandler implements handling REST endpoints of a single TODO,
+// /todos/{id}.
+type TODOItemHandler struct {
+	svc service.TODOStore
+}
+
+// NewTODOItemHandler returns TODOItemHandler based http.Handler.
+func NewTODOItemHandler(svc service.TODOStore) *TODOItemHandler {
+	return &TODOItemHandler{
+		svc: svc,
+	}
+}
+
+func (h *TODOItemHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/todos/"), 10, 64)
+	if err != nil || id <= 0 {
+		middleware.WriteProblem(w, r, http.StatusNotFound, "The path must be /todos/{id}")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		middleware.WriteProblem(w, r, http.StatusMethodNotAllowed, "Only GET is allowed")
+		return
+	}
+
+	version, err := h.svc.GetTODOVersion(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Warn("failed to read todo version", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	versions := []*model.TODOVersion{version}
+	if checkNotModified(w, r, todoETag(versions, mediaJSON), lastModified(versions, time.Time{})) {
+		return
+	}
+	todo, err := h.svc.GetTODO(r.Context(), id)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to read todo", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	w.Header().Set("Content-Type", mediaJSON)
+	encodeResponse(w, r, &model.GetTODOResponse{TODO: *todo})
+}
+
+// streamPageSize is the number of TODOs read from DB at a time when
+// streaming.
+const streamPageSize = 100
+
+// streamTODOs writes the TODOs selected by req as mediaType, reading and
+// flushing them page by page so that long lists hold neither memory nor the
+// database while the client reads.
+func (h *TODOHandler) streamTODOs(w http.ResponseWriter, r *http.Request, req *model.ReadTODORequest, mediaType string) {
+	ctx := r.Context()
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+
+	var enc todoEncoder
+	prevID, remaining := req.PrevID, req.Size
+	for {
+		size := int64(streamPageSize)
+		if remaining < size {
+			size = remaining
+		}
+		todos, err := h.svc.ReadListTODO(ctx, req.ListID, prevID, size)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to read todos", "err", err)
+			if enc == nil {
+				w.WriteHeader(statusFromError(err))
+			}
+			// otherwise the client gets a truncated body
+			return
+		}
+		if enc == nil {
+			w.Header().Set("Content-Type", mediaType)
+			if enc, err = newTODOEncoder(w, mediaType); err != nil {
+				logger.FromContext(ctx).Error("failed to encode response", "err", err)
+				return
+			}
+		}
+		for _, todo := range todos {
+			if err := enc.Encode(todo); err != nil {
+				logger.FromContext(ctx).Error("failed to encode response", "err", err)
+				return
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			logger.FromContext(ctx).Error("failed to encode response", "err", err)
+			return
+		}
+		flush()
+
+		remaining -= int64(len(todos))
+		if int64(len(todos)) < size || remaining <= 0 {
+			return
+		}
+		prevID = todos[len(todos)-1].ID
+	}
+}
+
 // Create handles the endpoint that creates the TODO.
 func (h *TODOHandler) Create(ctx context.Context, req *model.CreateTODORequest) (*model.CreateTODOResponse, error) {
 	_, _ = h.svc.CreateTODO(ctx, "", "")
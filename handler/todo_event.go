@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+const (
+	// eventPageSize is the number of events read from DB at a time.
+	eventPageSize = 100
+	// eventWriteTimeout bounds each write to the client, which is dropped
+	// when it cannot keep up. It resumes from where it was on reconnecting.
+	eventWriteTimeout = 10 * time.Second
+	// eventRetry is the reconnection delay advised to clients.
+	eventRetry = 3 * time.Second
+)
+
+// A TODOEventHandler streams the changes of the TODOs the user can read as
+// Server-Sent Events, /todos/events. Clients resume after the event in the
+// Last-Event-ID header, or the last_event_id parameter, and are sent a reset
+// event when the events since then are no longer kept.
+type TODOEventHandler struct {
+	svc       *service.TODOEventService
+	heartbeat time.Duration
+}
+
+// NewTODOEventHandler returns TODOEventHandler based http.Handler, sending a
+// comment every heartbeat so that idle streams are not closed by proxies.
+func NewTODOEventHandler(svc *service.TODOEventService, heartbeat time.Duration) *TODOEventHandler {
+	return &TODOEventHandler{
+		svc:       svc,
+		heartbeat: heartbeat,
+	}
+}
+
+func (h *TODOEventHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		middleware.WriteProblem(w, r, http.StatusMethodNotAllowed, "Only GET is allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		middleware.WriteProblem(w, r, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			middleware.WriteProblem(w, r, http.StatusBadRequest, "Last-Event-ID must be an event id")
+			return
+		}
+	}
+	first, last, err := h.svc.TODOEventRange(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to read todo events", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	// the events after lastID were pruned, or the log is not the one the
+	// client read
+	reset := lastEventID != "" && (lastID < first-1 || lastID > last)
+	if lastEventID == "" || reset {
+		lastID = last
+	}
+
+	// subscribed before reading, so that no addition goes unnoticed
+	wake, unsubscribe := h.svc.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// tells nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if reset {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", lastID)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		for {
+			// read whole pages before writing, so that a slow client does
+			// not hold DB
+			events, err := h.svc.ReadTODOEvents(ctx, lastID, eventPageSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.FromContext(ctx).Error("failed to read todo events", "err", err)
+				}
+				return
+			}
+			if len(events) == 0 {
+				break
+			}
+			setWriteDeadline(w, time.Now().Add(eventWriteTimeout))
+			for _, e := range events {
+				data, err := json.Marshal(e.TODO)
+				if err != nil {
+					logger.FromContext(ctx).Error("failed to encode todo event", "err", err)
+					return
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+					return
+				}
+				lastID = e.ID
+			}
+			flusher.Flush()
+			if len(events) < eventPageSize {
+				break
+			}
+		}
+
+		select {
+		case <-wake:
+		case <-heartbeat.C:
+			setWriteDeadline(w, time.Now().Add(eventWriteTimeout))
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		case <-h.svc.Done():
+			// the server shuts down; the client reconnects to another
+			return
+		}
+	}
+}
+
+// setWriteDeadline sets the write deadline of the connection of w, replacing
+// the server's WriteTimeout, which would end long streams. Servers built
+// before Go 1.20 do not support it and keep their WriteTimeout.
+func setWriteDeadline(w http.ResponseWriter, t time.Time) {
+	for {
+		switch rw := w.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			rw.SetWriteDeadline(t)
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// An sseEvent is a block of a Server-Sent Events stream.
+type sseEvent struct {
+	id, event, data, retry string
+	comments               []string
+}
+
+// An sseStream reads the events of a response to /todos/events.
+type sseStream struct {
+	t  *testing.T
+	br *bufio.Reader
+}
+
+// next returns the next block, failing the test when the stream ends.
+func (s *sseStream) next() *sseEvent {
+	s.t.Helper()
+	e := &sseEvent{}
+	for {
+		line, err := s.br.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e
+		}
+		if strings.HasPrefix(line, ":") {
+			e.comments = append(e.comments, strings.TrimSpace(line[1:]))
+			continue
+		}
+		field := strings.SplitN(line, ": ", 2)
+		if len(field) != 2 {
+			s.t.Fatalf("malformed line %q", line)
+		}
+		switch field[0] {
+		case "id":
+			e.id = field[1]
+		case "event":
+			e.event = field[1]
+		case "data":
+			e.data = field[1]
+		case "retry":
+			e.retry = field[1]
+		default:
+			s.t.Fatalf("unknown field %q", line)
+		}
+	}
+}
+
+// nextEvent returns the next block carrying an event, skipping heartbeats.
+func (s *sseStream) nextEvent() *sseEvent {
+	s.t.Helper()
+	for {
+		if e := s.next(); e.event != "" {
+			return e
+		}
+	}
+}
+
+func TestTODOEventHandler(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo_event.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	todos := service.NewTODOService(d)
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go events.Run(ctx)
+
+	h := handler.NewTODOEventHandler(events, 100*time.Millisecond)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// as authentication does
+		h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), r.URL.Query().Get("user"))))
+	}))
+	t.Cleanup(srv.Close)
+
+	open := func(query, lastEventID string) *sseStream {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/todos/events?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		reqCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+		resp, err := http.DefaultClient.Do(req.WithContext(reqCtx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET /todos/events?%s answered %d %s", query, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		s := &sseStream{t: t, br: bufio.NewReader(resp.Body)}
+		if e := s.next(); e.retry == "" {
+			t.Fatalf("stream starts with %+v, want the retry delay", e)
+		}
+		return s
+	}
+	subject := func(e *sseEvent) string {
+		t.Helper()
+		var todo struct {
+			Subject string `json:"subject"`
+		}
+		if err := json.Unmarshal([]byte(e.data), &todo); err != nil {
+			t.Fatalf("data %q: %v", e.data, err)
+		}
+		return todo.Subject
+	}
+
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+
+	// the users are sent the changes of their TODOs only
+	live := open("user=alice", "")
+	if _, err := todos.CreateTODO(bob, "bob's", ""); err != nil {
+		t.Fatal(err)
+	}
+	todo, err := todos.CreateTODO(alice, "first", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todos.UpdateTODO(alice, todo.ID, "second", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todos.UpdateTODO(alice, todo.ID, "third", ""); err != nil {
+		t.Fatal(err)
+	}
+	first := live.nextEvent()
+	if first.event != "created" || subject(first) != "first" {
+		t.Errorf("first event is %s of %q, want created of first", first.event, subject(first))
+	}
+	second := live.nextEvent()
+	if second.event != "updated" || subject(second) != "second" {
+		t.Errorf("second event is %s of %q, want updated of second", second.event, subject(second))
+	}
+	if e := live.nextEvent(); e.event != "updated" || subject(e) != "third" {
+		t.Errorf("third event is %s of %q, want updated of third", e.event, subject(e))
+	}
+
+	// idle streams are sent heartbeats
+	if e := live.next(); len(e.comments) != 1 || e.comments[0] != "heartbeat" || e.event != "" {
+		t.Errorf("idle stream sent %+v, want a heartbeat", e)
+	}
+
+	// reconnecting resumes after the last event read, from either
+	for name, s := range map[string]*sseStream{
+		"Last-Event-ID": open("user=alice", first.id),
+		"last_event_id": open("user=alice&last_event_id="+first.id, ""),
+	} {
+		if e := s.nextEvent(); e.id != second.id || subject(e) != "second" {
+			t.Errorf("%s resumed with %s %q, want %s second", name, e.id, subject(e), second.id)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/todos/events", nil)
+	req.Header.Set("Last-Event-ID", "latest")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid Last-Event-ID answered %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// the events after the one read were pruned
+	if _, err := d.Exec(`DELETE FROM todo_events WHERE id <= ?`, second.id); err != nil {
+		t.Fatal(err)
+	}
+	stale := open("user=alice", first.id)
+	reset := stale.nextEvent()
+	if reset.event != "reset" || reset.id == "" {
+		t.Errorf("stale stream started with %+v, want a reset", reset)
+	}
+	if _, err := todos.UpdateTODO(alice, todo.ID, "fourth", ""); err != nil {
+		t.Fatal(err)
+	}
+	if e := stale.nextEvent(); e.event != "updated" || subject(e) != "fourth" {
+		t.Errorf("after the reset, received %s of %q, want updated of fourth", e.event, subject(e))
+	}
+
+	// shutting down ends the streams
+	events.Close()
+	for {
+		if _, err := live.br.ReadString('\n'); err != nil {
+			if err != io.EOF {
+				t.Errorf("stream ended with %v, want EOF", err)
+			}
+			break
+		}
+	}
+}
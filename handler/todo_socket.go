@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// socketQueueSize is the number of messages waiting to be written to a
+	// connection before the commands and events wait too.
+	socketQueueSize = 16
+	// socketWriteTimeout bounds each write to the client, which is dropped
+	// when it cannot keep up.
+	socketWriteTimeout = 10 * time.Second
+)
+
+// A TODOSocketHandler implements the WebSocket API, /todos/ws. Over one
+// connection, clients send create, update and delete commands, validated as
+// the REST endpoints do, and subscribe to the events of lists. Every command
+// is answered with the ID the client gave it, in order.
+//
+// Only same-origin browsers may connect, as they send their credentials
+// along with cross-origin handshakes too.
+type TODOSocketHandler struct {
+	store    service.TODOStore
+	events   *service.TODOEventService
+	cfg      TODOSocketConfig
+	upgrader websocket.Upgrader
+}
+
+// A TODOSocketConfig configures TODOSocketHandler. Clients are pinged every
+// Heartbeat and dropped when not answering within two, or when sending
+// messages over MaxBytes, which also bounds the TODOs a subscription answers.
+// Each command is accounted to RateLimit, unlimited when nil, and canceled
+// after Timeout, unbounded when zero, as a REST request would be.
+type TODOSocketConfig struct {
+	Heartbeat time.Duration
+	MaxBytes  int64
+	Timeout   time.Duration
+	RateLimit *middleware.RateLimiter
+}
+
+// NewTODOSocketHandler returns TODOSocketHandler based http.Handler.
+func NewTODOSocketHandler(store service.TODOStore, events *service.TODOEventService, cfg TODOSocketConfig) *TODOSocketHandler {
+	return &TODOSocketHandler{
+		store:  store,
+		events: events,
+		cfg:    cfg,
+	}
+}
+
+// A socketConn is the state of a connection shared by its goroutines.
+type socketConn struct {
+	ws  *websocket.Conn
+	out chan *model.SocketMessage
+	// r is the handshake, whose client the commands are accounted to
+	r *http.Request
+
+	mu    sync.Mutex
+	lists map[int64]bool
+}
+
+// subscribed reports whether the events of the list are sent, all of them
+// after a subscription to the zero list.
+func (c *socketConn) subscribed(listID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lists[0] || c.lists[listID]
+}
+
+func (h *TODOSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		middleware.WriteProblem(w, r, http.StatusUpgradeRequired, "The connection must be upgraded to WebSocket")
+		return
+	}
+	// events after this one are sent to the subscriptions
+	_, lastID, err := h.events.TODOEventRange(r.Context())
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to read todo events", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader answered already
+		logger.FromContext(r.Context()).Warn("failed to upgrade to websocket", "err", err)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(h.cfg.MaxBytes)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c := &socketConn{
+		ws:    ws,
+		out:   make(chan *model.SocketMessage, socketQueueSize),
+		r:     r,
+		lists: map[int64]bool{},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// closing the connection ends readLoop
+		defer ws.Close()
+		defer cancel()
+		h.writeLoop(ctx, c)
+	}()
+	go func() {
+		defer wg.Done()
+		defer ws.Close()
+		defer cancel()
+		if err := h.eventLoop(ctx, c, lastID); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Error("failed to read todo events", "err", err)
+		}
+	}()
+	h.readLoop(ctx, c)
+	cancel()
+	wg.Wait()
+}
+
+// readLoop runs the commands of the client in order until the connection
+// ends.
+func (h *TODOSocketHandler) readLoop(ctx context.Context, c *socketConn) {
+	pongWait := 2 * h.cfg.Heartbeat
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.FromContext(ctx).Debug("websocket closed", "err", err)
+			}
+			return
+		}
+		var msg *model.SocketMessage
+		req := &model.SocketRequest{}
+		if err := decodeJSON(bytes.NewReader(data), req); err != nil {
+			msg = socketError(req.ID, http.StatusBadRequest, err.Error())
+		} else {
+			msg = h.run(ctx, c, req)
+		}
+		select {
+		case c.out <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeLoop writes the messages queued and the pings, the only writer of the
+// connection, until ctx is done or the server shuts down.
+func (h *TODOSocketHandler) writeLoop(ctx context.Context, c *socketConn) {
+	ping := time.NewTicker(h.cfg.Heartbeat)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-c.out:
+			c.ws.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			if err := c.ws.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout)); err != nil {
+				return
+			}
+		case <-h.events.Done():
+			// hijacked connections are not drained by the server
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			c.ws.WriteControl(websocket.CloseMessage, closing, time.Now().Add(socketWriteTimeout))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eventLoop queues the events after lastID of the subscribed lists, reading
+// them only as fast as the client takes them.
+func (h *TODOSocketHandler) eventLoop(ctx context.Context, c *socketConn, lastID int64) error {
+	wake, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+	for {
+		for {
+			events, err := h.events.ReadTODOEvents(ctx, lastID, eventPageSize)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				lastID = e.ID
+				if !c.subscribed(e.TODO.ListID) {
+					continue
+				}
+				select {
+				case c.out <- &model.SocketMessage{Type: model.SocketEvent, Payload: e}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			if len(events) < eventPageSize {
+				break
+			}
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// run runs the command req within the rate limit and timeout and returns
+// its response.
+func (h *TODOSocketHandler) run(ctx context.Context, c *socketConn, req *model.SocketRequest) *model.SocketMessage {
+	if h.cfg.RateLimit != nil {
+		if ok, wait := h.cfg.RateLimit.Allow(c.r); !ok {
+			detail := fmt.Sprintf("Rate limit exceeded, retry after %d seconds", int(math.Ceil(wait.Seconds())))
+			return socketError(req.ID, http.StatusTooManyRequests, detail)
+		}
+	}
+	if h.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Timeout)
+		defer cancel()
+	}
+	return h.handle(ctx, c, req)
+}
+
+// handle runs the command req and returns its response.
+func (h *TODOSocketHandler) handle(ctx context.Context, c *socketConn, req *model.SocketRequest) *model.SocketMessage {
+	payload := req.Payload
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	switch req.Type {
+	case model.SocketSubscribe:
+		p := &model.SubscribeRequest{}
+		if err := decodeJSON(bytes.NewReader(payload), p); err != nil {
+			return socketError(req.ID, http.StatusBadRequest, err.Error())
+		}
+		// subscribed before reading, so that no event after the TODOs read is
+		// missed; events after lastID are sent, which may repeat them
+		c.mu.Lock()
+		subscribed := c.lists[p.ListID]
+		c.lists[p.ListID] = true
+		c.mu.Unlock()
+		_, lastID, err := h.events.TODOEventRange(ctx)
+		var (
+			todos     []*model.TODO
+			truncated bool
+		)
+		if err == nil {
+			todos, truncated, err = h.snapshot(ctx, p.ListID)
+		}
+		if err != nil {
+			if !subscribed {
+				c.mu.Lock()
+				delete(c.lists, p.ListID)
+				c.mu.Unlock()
+			}
+			return h.fail(ctx, req, err)
+		}
+		return socketResponse(req.ID, &model.SubscribeResponse{TODOs: todos, LastEventID: lastID, Truncated: truncated})
+	case model.SocketUnsubscribe:
+		p := &model.UnsubscribeRequest{}
+		if err := decodeJSON(bytes.NewReader(payload), p); err != nil {
+			return socketError(req.ID, http.StatusBadRequest, err.Error())
+		}
+		c.mu.Lock()
+		delete(c.lists, p.ListID)
+		c.mu.Unlock()
+		return socketResponse(req.ID, &model.UnsubscribeResponse{})
+	case model.SocketCreate:
+		p := &model.CreateTODORequest{}
+		if err := decodeJSON(bytes.NewReader(payload), p); err != nil {
+			return socketError(req.ID, http.StatusBadRequest, err.Error())
+		}
+		if p.Subject == "" {
+			return socketError(req.ID, http.StatusBadRequest, "subject must not be empty")
+		}
+		todo, err := h.store.CreateListTODO(ctx, p.ListID, p.Subject, p.Description)
+		if err != nil {
+			return h.fail(ctx, req, err)
+		}
+		return socketResponse(req.ID, &model.CreateTODOResponse{TODO: *todo})
+	case model.SocketUpdate:
+		p := &model.UpdateTODORequest{}
+		if err := decodeJSON(bytes.NewReader(payload), p); err != nil {
+			return socketError(req.ID, http.StatusBadRequest, err.Error())
+		}
+		if p.ID == 0 || p.Subject == "" {
+			return socketError(req.ID, http.StatusBadRequest, "id and subject must not be empty")
+		}
+		todo, err := h.store.UpdateTODO(ctx, p.ID, p.Subject, p.Description)
+		if err != nil {
+			return h.fail(ctx, req, err)
+		}
+		return socketResponse(req.ID, &model.UpdateTODOResponse{TODO: *todo})
+	case model.SocketDelete:
+		p := &model.DeleteTODORequest{}
+		if err := decodeJSON(bytes.NewReader(payload), p); err != nil {
+			return socketError(req.ID, http.StatusBadRequest, err.Error())
+		}
+		if len(p.IDs) == 0 {
+			return socketError(req.ID, http.StatusBadRequest, "ids must not be empty")
+		}
+		if err := h.store.DeleteTODO(ctx, p.IDs); err != nil {
+			return h.fail(ctx, req, err)
+		}
+		return socketResponse(req.ID, &model.DeleteTODOResponse{})
+	default:
+		return socketError(req.ID, http.StatusBadRequest, "unknown type "+req.Type)
+	}
+}
+
+// snapshot reads the TODOs of the list, newest first, as many as encode
+// within MaxBytes. It reports whether older TODOs were left out.
+func (h *TODOSocketHandler) snapshot(ctx context.Context, listID int64) ([]*model.TODO, bool, error) {
+	todos := []*model.TODO{}
+	var size, prevID int64
+	for {
+		page, err := h.store.ReadListTODO(ctx, listID, prevID, streamPageSize)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, todo := range page {
+			b, err := json.Marshal(todo)
+			if err != nil {
+				return nil, false, err
+			}
+			size += int64(len(b)) + 1
+			if size > h.cfg.MaxBytes {
+				return todos, true, nil
+			}
+			todos = append(todos, todo)
+		}
+		if len(page) < streamPageSize {
+			return todos, false, nil
+		}
+		prevID = page[len(page)-1].ID
+	}
+}
+
+// fail returns the error response of req failing with err. Internal errors
+// are told by their status alone, as the REST endpoints do.
+func (h *TODOSocketHandler) fail(ctx context.Context, req *model.SocketRequest, err error) *model.SocketMessage {
+	status := statusFromError(err)
+	detail := err.Error()
+	if status >= http.StatusInternalServerError {
+		logger.FromContext(ctx).Error("failed to run websocket command", "type", req.Type, "err", err)
+		detail = http.StatusText(status)
+	}
+	return socketError(req.ID, status, detail)
+}
+
+func socketResponse(id string, payload interface{}) *model.SocketMessage {
+	return &model.SocketMessage{ID: id, Type: model.SocketResponse, Status: http.StatusOK, Payload: payload}
+}
+
+func socketError(id string, status int, detail string) *model.SocketMessage {
+	return &model.SocketMessage{ID: id, Type: model.SocketError, Status: status, Detail: detail}
+}
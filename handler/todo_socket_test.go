@@ -0,0 +1,240 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/gorilla/websocket"
+)
+
+// socketMessage is model.SocketMessage with the payload left to decode.
+type socketMessage struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Status  int             `json:"status"`
+	Detail  string          `json:"detail"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func TestTODOSocketHandler(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo_socket.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	todos := service.NewTODOService(d)
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go events.Run(ctx)
+
+	h := handler.NewTODOSocketHandler(todos, events, handler.TODOSocketConfig{Heartbeat: time.Second, MaxBytes: 1 << 10})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// as authentication does
+		h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), r.URL.Query().Get("user"))))
+	}))
+	t.Cleanup(srv.Close)
+
+	dial := func(user string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/todos/ws?user="+user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	read := func(ws *websocket.Conn) *socketMessage {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		msg := &socketMessage{}
+		if err := ws.ReadJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	alice := dial("alice")
+	defer alice.Close()
+	bob := dial("bob")
+	defer bob.Close()
+	for _, ws := range []*websocket.Conn{alice, bob} {
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"s","type":"subscribe","payload":{"list_id":0}}`))
+		if msg := read(ws); msg.ID != "s" || msg.Status != http.StatusOK {
+			t.Fatalf("subscribe answered %+v", msg)
+		}
+	}
+
+	cases := []struct {
+		request    string
+		wantID     string
+		wantStatus int
+	}{
+		{request: `{"id":"c1","type":"create","payload":{"subject":"alice's"}}`, wantID: "c1", wantStatus: http.StatusOK},
+		{request: `{"id":"c2","type":"create","payload":{"subject":""}}`, wantID: "c2", wantStatus: http.StatusBadRequest},
+		{request: `{"id":"c3","type":"create","payload":{"subject":"x","unknown":1}}`, wantID: "c3", wantStatus: http.StatusBadRequest},
+		{request: `{"id":"u1","type":"update","payload":{"id":999,"subject":"missing"}}`, wantID: "u1", wantStatus: http.StatusNotFound},
+		{request: `{"id":"d1","type":"delete","payload":{"ids":[]}}`, wantID: "d1", wantStatus: http.StatusBadRequest},
+		{request: `{"id":"x1","type":"rename"}`, wantID: "x1", wantStatus: http.StatusBadRequest},
+		{request: `not json`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if err := alice.WriteMessage(websocket.TextMessage, []byte(tc.request)); err != nil {
+			t.Fatal(err)
+		}
+		// the event of the creation may come first
+		msg := read(alice)
+		for msg.Type == "event" {
+			msg = read(alice)
+		}
+		if msg.ID != tc.wantID || msg.Status != tc.wantStatus {
+			t.Errorf("%s answered %s %d %q, want %s %d", tc.request, msg.ID, msg.Status, msg.Detail, tc.wantID, tc.wantStatus)
+		}
+	}
+
+	// bob cannot read alice's TODO, so only his own creation reaches him
+	bob.WriteMessage(websocket.TextMessage, []byte(`{"id":"c1","type":"create","payload":{"subject":"bob's"}}`))
+	for {
+		msg := read(bob)
+		if msg.Type != "event" {
+			continue
+		}
+		var e struct {
+			Type string `json:"type"`
+			TODO struct {
+				Subject string `json:"subject"`
+			} `json:"todo"`
+		}
+		if err := json.Unmarshal(msg.Payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != "created" || e.TODO.Subject != "bob's" {
+			t.Errorf("bob received %s of %q", e.Type, e.TODO.Subject)
+		}
+		break
+	}
+
+	// too large messages close the connection
+	alice.WriteMessage(websocket.TextMessage, []byte(`{"id":"big","type":"create","payload":{"subject":"`+strings.Repeat("a", 2<<10)+`"}}`))
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := alice.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Errorf("err = %v, want close for a too large message", err)
+			}
+			break
+		}
+	}
+
+	// shutting down closes the connections
+	events.Close()
+	bob.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := bob.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("err = %v, want close going away", err)
+			}
+			break
+		}
+	}
+}
+
+// blockingStore answers creations only once their context is done.
+type blockingStore struct {
+	service.TODOStore
+}
+
+func (s blockingStore) CreateListTODO(ctx context.Context, listID int64, subject, description string) (*model.TODO, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTODOSocketHandlerLimits(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo_socket.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	todos := service.NewTODOService(d)
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	t.Cleanup(events.Close)
+
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	for i := 0; i < 150; i++ {
+		if _, err := todos.CreateTODO(alice, fmt.Sprintf("todo %d", i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := handler.NewTODOSocketHandler(blockingStore{todos}, events, handler.TODOSocketConfig{
+		Heartbeat: time.Second,
+		MaxBytes:  4 << 10,
+		Timeout:   50 * time.Millisecond,
+		RateLimit: middleware.NewRateLimiter(middleware.RateLimit{Rate: 0.001, Burst: 3}),
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), "alice")))
+	}))
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/todos/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	do := func(request string) *socketMessage {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		msg := &socketMessage{}
+		if err := ws.ReadJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// the snapshot is cut at the size limit, newest first
+	msg := do(`{"id":"s","type":"subscribe","payload":{"list_id":0}}`)
+	var snapshot struct {
+		TODOs []struct {
+			Subject string `json:"subject"`
+		} `json:"todos"`
+		Truncated bool `json:"truncated"`
+	}
+	if err := json.Unmarshal(msg.Payload, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != http.StatusOK || !snapshot.Truncated || len(snapshot.TODOs) == 0 || len(snapshot.TODOs) >= 150 {
+		t.Errorf("subscribe answered %d with %d TODOs, truncated %t", msg.Status, len(snapshot.TODOs), snapshot.Truncated)
+	}
+	if len(snapshot.TODOs) > 0 && snapshot.TODOs[0].Subject != "todo 149" {
+		t.Errorf("snapshot starts with %q, want the newest", snapshot.TODOs[0].Subject)
+	}
+
+	// commands time out as requests do
+	if msg := do(`{"id":"c1","type":"create","payload":{"subject":"slow"}}`); msg.Status != http.StatusServiceUnavailable {
+		t.Errorf("slow create answered %d %q, want %d", msg.Status, msg.Detail, http.StatusServiceUnavailable)
+	}
+	if msg := do(`{"id":"u1","type":"unsubscribe","payload":{"list_id":0}}`); msg.Status != http.StatusOK {
+		t.Errorf("unsubscribe answered %d %q, want %d", msg.Status, msg.Detail, http.StatusOK)
+	}
+	// the burst is spent
+	if msg := do(`{"id":"u2","type":"unsubscribe","payload":{"list_id":0}}`); msg.ID != "u2" || msg.Status != http.StatusTooManyRequests {
+		t.Errorf("limited command answered %s %d %q, want %d", msg.ID, msg.Status, msg.Detail, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,138 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestTODOHandlerFormats(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	todos := service.NewTODOService(d)
+	for _, subject := range []string{"milk", "=SUM(1)", "+1", "-1", "@cmd", "a=b", "\t=1", "\r=1"} {
+		if _, err := todos.CreateTODO(auth.ContextWithUser(context.Background(), "alice"), subject, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// newest first
+	want := []string{"\r=1", "\t=1", "a=b", "@cmd", "-1", "+1", "=SUM(1)", "milk"}
+	h := handler.NewTODOHandler(todos)
+
+	cases := map[string]struct {
+		accept      string
+		contentType string
+		decode      func(t *testing.T, w *httptest.ResponseRecorder) []string
+		want        []string
+	}{
+		"NDJSON": {
+			accept:      "application/x-ndjson",
+			contentType: "application/x-ndjson",
+			decode: func(t *testing.T, w *httptest.ResponseRecorder) []string {
+				var got []string
+				sc := bufio.NewScanner(w.Body)
+				for sc.Scan() {
+					var todo model.TODO
+					if err := json.Unmarshal(sc.Bytes(), &todo); err != nil {
+						t.Fatalf("line %q: %v", sc.Text(), err)
+					}
+					got = append(got, todo.Subject)
+				}
+				return got
+			},
+			want: want,
+		},
+		"CSV": {
+			accept:      "text/csv",
+			contentType: "text/csv",
+			decode: func(t *testing.T, w *httptest.ResponseRecorder) []string {
+				records, err := csv.NewReader(w.Body).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(records) == 0 || records[0][1] != "subject" {
+					t.Fatalf("unexpected header, given = %v", records)
+				}
+				var got []string
+				for _, record := range records[1:] {
+					got = append(got, record[1])
+				}
+				return got
+			},
+			// formulas are quoted as text
+			want: []string{"'\r=1", "'\t=1", "a=b", "'@cmd", "'-1", "'+1", "'=SUM(1)", "milk"},
+		},
+		"Msgpack": {
+			accept:      "application/x-msgpack",
+			contentType: "application/msgpack",
+			decode: func(t *testing.T, w *httptest.ResponseRecorder) []string {
+				var resp struct {
+					TODOs []struct {
+						Subject string `msgpack:"subject"`
+					} `msgpack:"todos"`
+				}
+				if err := msgpack.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, todo := range resp.TODOs {
+					got = append(got, todo.Subject)
+				}
+				return got
+			},
+			want: want,
+		},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+			r = r.WithContext(auth.ContextWithUser(r.Context(), "alice"))
+			r.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tc.contentType)
+			}
+			if got := tc.decode(t, w); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("subjects = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("Not acceptable", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		r.Header.Set("Accept", "image/png")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotAcceptable {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNotAcceptable)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Vary = %q, want Accept", got)
+		}
+	})
+}
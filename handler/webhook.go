@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// webhookDeliveryPageSize is the number of deliveries read at a time.
+const webhookDeliveryPageSize = 100
+
+// A WebhookHandler implements handling REST endpoints of webhooks.
+type WebhookHandler struct {
+	svc *service.WebhookService
+}
+
+// NewWebhookHandler returns WebhookHandler based http.Handler.
+func NewWebhookHandler(svc *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		svc: svc,
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		webhooks, err := h.svc.ReadWebhooks(r.Context())
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to read webhooks", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.ReadWebhookResponse{Webhooks: webhooks})
+	case http.MethodPost:
+		req := &model.CreateWebhookRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if detail := checkWebhook(req.Events); detail != "" {
+			middleware.WriteProblem(w, r, http.StatusBadRequest, detail)
+			return
+		}
+		webhook, err := h.svc.CreateWebhook(r.Context(), req.URL, req.Secret, req.Events)
+		if err != nil {
+			writeWebhookError(w, r, "failed to create webhook", err)
+			return
+		}
+		encodeResponse(w, r, &model.CreateWebhookResponse{Webhook: *webhook})
+	case http.MethodPut:
+		req := &model.UpdateWebhookRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ID == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if detail := checkWebhook(req.Events); detail != "" {
+			middleware.WriteProblem(w, r, http.StatusBadRequest, detail)
+			return
+		}
+		webhook, err := h.svc.UpdateWebhook(r.Context(), req.ID, req.URL, req.Secret, req.Events, req.Active)
+		if err != nil {
+			writeWebhookError(w, r, "failed to update webhook", err)
+			return
+		}
+		encodeResponse(w, r, &model.UpdateWebhookResponse{Webhook: *webhook})
+	case http.MethodDelete:
+		req := &model.DeleteWebhookRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		if req.ID == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := h.svc.DeleteWebhook(r.Context(), req.ID); err != nil {
+			logger.FromContext(r.Context()).Error("failed to delete webhook", "err", err)
+			w.WriteHeader(statusFromError(err))
+			return
+		}
+		encodeResponse(w, r, &model.DeleteWebhookResponse{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkWebhook returns why a webhook posting the events cannot be made, or an
+// empty string. The URL is checked by WebhookService, which resolves it.
+func checkWebhook(events []model.TODOEventType) string {
+	for _, e := range events {
+		if !e.Valid() {
+			return "events must be created, updated or deleted"
+		}
+	}
+	return ""
+}
+
+// writeWebhookError answers err of creating or updating a webhook, telling
+// why its URL was refused.
+func writeWebhookError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	var badURL *model.ErrInvalidWebhookURL
+	if errors.As(err, &badURL) {
+		middleware.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.FromContext(r.Context()).Error(msg, "err", err)
+	w.WriteHeader(statusFromError(err))
+}
+
+// A WebhookDeliveryHandler implements reading the delivery log of a webhook.
+type WebhookDeliveryHandler struct {
+	svc *service.WebhookService
+}
+
+// NewWebhookDeliveryHandler returns WebhookDeliveryHandler based http.Handler.
+func NewWebhookDeliveryHandler(svc *service.WebhookService) *WebhookDeliveryHandler {
+	return &WebhookDeliveryHandler{
+		svc: svc,
+	}
+}
+
+func (h *WebhookDeliveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	webhookID, err := strconv.ParseInt(r.URL.Query().Get("webhook_id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var prevID int64
+	if v := r.URL.Query().Get("prev_id"); v != "" {
+		if prevID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	deliveries, err := h.svc.ReadWebhookDeliveries(r.Context(), webhookID, prevID, webhookDeliveryPageSize)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to read webhook deliveries", "err", err)
+		w.WriteHeader(statusFromError(err))
+		return
+	}
+	encodeResponse(w, r, &model.ReadWebhookDeliveryResponse{Deliveries: deliveries})
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+)
+
+// DBPing checks that d answers a ping.
+func DBPing(d *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return d.PingContext(ctx)
+	})
+}
+
+// Migrations checks that every embedded migration has been applied to d.
+func Migrations(d *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		migrations, err := db.Migrations()
+		if err != nil {
+			return err
+		}
+		version, err := db.SchemaVersion(d)
+		if err != nil {
+			return err
+		}
+		want := 0
+		if len(migrations) > 0 {
+			want = migrations[len(migrations)-1].Version
+		}
+		if version < want {
+			return fmt.Errorf("schema version %d is behind migration %d", version, want)
+		}
+		return nil
+	})
+}
+
+// DiskSpace checks that the file system holding path has at least minFree
+// bytes available.
+func DiskSpace(path string, minFree uint64) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		free, err := freeBytes(path)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d bytes free on %s, want at least %d", free, path, minFree)
+		}
+		return nil
+	})
+}
+
+// A Heartbeat checks a background worker that beats on every iteration of
+// its loop. The worker is considered stuck once no beat arrived for maxAge.
+type Heartbeat struct {
+	maxAge time.Duration
+	last   int64
+}
+
+// NewHeartbeat returns a Heartbeat that has just beaten.
+func NewHeartbeat(maxAge time.Duration) *Heartbeat {
+	hb := &Heartbeat{maxAge: maxAge}
+	hb.Beat()
+	return hb
+}
+
+// Beat records that the worker is alive. It is safe on a nil Heartbeat.
+func (hb *Heartbeat) Beat() {
+	if hb == nil {
+		return
+	}
+	atomic.StoreInt64(&hb.last, time.Now().UnixNano())
+}
+
+// Check implements Checker interface.
+func (hb *Heartbeat) Check(ctx context.Context) error {
+	last := time.Unix(0, atomic.LoadInt64(&hb.last))
+	if age := time.Since(last); age > hb.maxAge {
+		return fmt.Errorf("last heartbeat %s ago", age.Truncate(time.Second))
+	}
+	return nil
+}
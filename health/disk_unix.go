@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package health
+
+import (
+	"syscall"
+)
+
+func freeBytes(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package health
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func freeBytes(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	var free uint64
+	if r, _, err := proc.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,115 @@
+// Package health runs the checks behind the readiness probe.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A Checker reports whether one dependency of the server is usable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check implements Checker interface.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Status values of a check and of the whole report.
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout bounds each check unless the Health is given another.
+const DefaultTimeout = 2 * time.Second
+
+type namedChecker struct {
+	name string
+	Checker
+}
+
+// A Health runs registered checkers concurrently.
+type Health struct {
+	mu       sync.RWMutex
+	timeout  time.Duration
+	checkers []namedChecker
+}
+
+// New returns a Health giving every check at most timeout, or DefaultTimeout
+// when timeout is zero.
+func New(timeout time.Duration) *Health {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Health{timeout: timeout}
+}
+
+// Register adds c under name.
+func (h *Health) Register(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers = append(h.checkers, namedChecker{name: name, Checker: c})
+}
+
+// Check runs every checker and reports whether all of them passed.
+func (h *Health) Check(ctx context.Context) (*model.HealthResponse, bool) {
+	h.mu.RLock()
+	checkers := append([]namedChecker(nil), h.checkers...)
+	h.mu.RUnlock()
+
+	results := make([]model.HealthCheck, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		i, c := i, c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.run(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	res := &model.HealthResponse{Status: StatusPass, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusPass {
+			res.Status = StatusFail
+		}
+	}
+	return res, res.Status == StatusPass
+}
+
+func (h *Health) run(ctx context.Context, c namedChecker) model.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// a checker ignoring ctx must not hold the probe
+		err = ctx.Err()
+	}
+
+	res := model.HealthCheck{
+		Name:     c.name,
+		Status:   StatusPass,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,50 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/health"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	h := health.New(50 * time.Millisecond)
+	h.Register("ok", health.CheckerFunc(func(ctx context.Context) error { return nil }))
+	h.Register("heartbeat", health.NewHeartbeat(time.Minute))
+
+	if res, ok := h.Check(context.Background()); !ok || res.Status != health.StatusPass || len(res.Checks) != 2 {
+		t.Fatalf("passing checks reported %+v", res)
+	}
+
+	h.Register("broken", health.CheckerFunc(func(ctx context.Context) error { return errors.New("broken") }))
+	h.Register("stuck", health.CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+	h.Register("stale", health.NewHeartbeat(-time.Second))
+
+	start := time.Now()
+	res, ok := h.Check(context.Background())
+	if ok || res.Status != health.StatusFail {
+		t.Fatalf("failing checks reported %+v", res)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("a stuck checker held the probe for %s", elapsed)
+	}
+	want := map[string]string{
+		"ok":        health.StatusPass,
+		"heartbeat": health.StatusPass,
+		"broken":    health.StatusFail,
+		"stuck":     health.StatusFail,
+		"stale":     health.StatusFail,
+	}
+	for _, c := range res.Checks {
+		if c.Status != want[c.Name] {
+			t.Errorf("check %s = %s (%s), want %s", c.Name, c.Status, c.Error, want[c.Name])
+		}
+	}
+}
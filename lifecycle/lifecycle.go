@@ -0,0 +1,333 @@
+// Package lifecycle runs the HTTP servers and background workers of the
+// process and shuts them down in order on SIGINT or SIGTERM.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+// ErrShuttingDown is reported by the readiness check once shutdown started.
+var ErrShuttingDown = errors.New("lifecycle: shutting down")
+
+// A Config tunes the shutdown sequence.
+type Config struct {
+	// ShutdownDelay is how long readiness fails before the servers stop
+	// accepting connections, so that load balancers stop routing first.
+	ShutdownDelay time.Duration
+	// DrainTimeout bounds the wait for in-flight requests, and then for
+	// each worker and stop hook.
+	DrainTimeout time.Duration
+}
+
+// DefaultConfig returns the Config used when none is configured.
+func DefaultConfig() Config {
+	return Config{
+		DrainTimeout: 10 * time.Second,
+	}
+}
+
+// restartGrace is how long a process replaced by a restart keeps the
+// connections it accepted before closing its listeners, so that they send
+// their request before the drain, which would drop them otherwise.
+const restartGrace = time.Second
+
+type server struct {
+	srv   *http.Server
+	ln    net.Listener
+	addr  string
+	serve func() error
+}
+
+// A onceCloseListener ignores the Close calls after the first, as the
+// listeners are closed before the server shuts down when restarting.
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() { l.err = l.Listener.Close() })
+	return l.err
+}
+
+// A component is a worker or a stop hook, stopped in reverse order of
+// registration.
+type component struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// A Manager owns the servers, background workers and resources of the
+// process.
+type Manager struct {
+	cfg Config
+	log *logger.Logger
+
+	mu         sync.Mutex
+	servers    []server
+	components []component
+	started    bool
+
+	restart   func(ctx context.Context) error
+	restarted bool
+	draining  int32
+	failed    chan error
+	once      sync.Once
+	err       error
+}
+
+// New returns a Manager shutting down as cfg tells.
+func New(cfg Config, log *logger.Logger) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		log:    log,
+		failed: make(chan error, 1),
+	}
+}
+
+// AddServer registers srv, started by Run with serve, e.g. srv.ListenAndServe.
+func (m *Manager) AddServer(srv *http.Server, serve func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, server{srv: srv, addr: srv.Addr, serve: serve})
+}
+
+// AddListener registers srv to serve connections accepted by ln, started by
+// Run with serve, e.g. srv.Serve. A server may be added on several listeners.
+func (m *Manager) AddListener(srv *http.Server, ln net.Listener, serve func(ln net.Listener) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oln := &onceCloseListener{Listener: ln}
+	m.servers = append(m.servers, server{
+		srv:   srv,
+		ln:    oln,
+		addr:  ln.Addr().Network() + "://" + ln.Addr().String(),
+		serve: func() error { return serve(oln) },
+	})
+}
+
+// Go starts run as a background worker. Its context is canceled when the
+// worker is stopped; if run fails before that, the process shuts down.
+func (m *Manager) Go(name string, run func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		err = run(ctx)
+		if err != nil && ctx.Err() == nil {
+			m.log.Error("worker failed", "worker", name, "err", err)
+			select {
+			case m.failed <- err:
+			default:
+			}
+		}
+	}()
+	m.Defer(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Defer registers stop to release a resource, such as closing the database,
+// once the servers are drained and the workers registered later stopped.
+func (m *Manager) Defer(name string, stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, component{name: name, stop: stop})
+}
+
+// OnRestart registers restart, called by Run on SIGUSR2 to start the process
+// replacing this one. Once it succeeded, the manager shuts down as on
+// SIGTERM; when it fails, this process keeps serving.
+func (m *Manager) OnRestart(restart func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restart = restart
+}
+
+// Check implements health.Checker interface. It fails once shutdown started.
+func (m *Manager) Check(ctx context.Context) error {
+	if atomic.LoadInt32(&m.draining) != 0 {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// Run starts the servers and blocks until ctx is done, SIGINT or SIGTERM is
+// received, a restart succeeded, or a server or worker fails. It then stops
+// everything and returns the first error met.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m.mu.Lock()
+	servers := append([]server(nil), m.servers...)
+	restart := m.restart
+	m.started = true
+	m.mu.Unlock()
+
+	restartc := make(chan os.Signal, 1)
+	if restart != nil && len(restartSignals) > 0 {
+		signal.Notify(restartc, restartSignals...)
+		defer signal.Stop(restartc)
+	}
+
+	errc := make(chan error, len(servers))
+	for _, s := range servers {
+		s := s
+		go func() {
+			m.log.Info("server listening", "addr", s.addr)
+			if err := s.serve(); err != nil && err != http.ErrServerClosed {
+				errc <- err
+			}
+		}()
+	}
+
+	var err error
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			m.log.Info("shutdown requested")
+			break wait
+		case <-restartc:
+			m.log.Info("restart requested")
+			if restartErr := restart(ctx); restartErr != nil {
+				m.log.Error("restart failed, still serving", "err", restartErr)
+				continue
+			}
+			m.log.Info("replaced by new process")
+			m.mu.Lock()
+			m.restarted = true
+			m.mu.Unlock()
+			break wait
+		case err = <-errc:
+			m.log.Error("server failed", "err", err)
+			break wait
+		case err = <-m.failed:
+			break wait
+		}
+	}
+	if stopErr := m.Stop(); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// Stop fails readiness, drains the servers, then stops workers and runs stop
+// hooks in reverse order of registration. Only the first call has an effect;
+// later calls return its result.
+func (m *Manager) Stop() error {
+	m.once.Do(func() { m.err = m.stop() })
+	return m.err
+}
+
+func (m *Manager) stop() error {
+	atomic.StoreInt32(&m.draining, 1)
+
+	m.mu.Lock()
+	// a server added on several listeners is shut down once
+	var servers []*http.Server
+	seen := map[*http.Server]bool{}
+	for _, s := range m.servers {
+		if !seen[s.srv] {
+			seen[s.srv] = true
+			servers = append(servers, s.srv)
+		}
+	}
+	var listeners []net.Listener
+	for _, s := range m.servers {
+		if s.ln != nil {
+			listeners = append(listeners, s.ln)
+		}
+	}
+	components := append([]component(nil), m.components...)
+	started, restarted := m.started, m.restarted
+	m.mu.Unlock()
+
+	var first error
+	record := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	if started && len(servers) > 0 {
+		delay := m.cfg.ShutdownDelay
+		if restarted {
+			// the new process accepts on the same sockets already
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			delay = restartGrace
+		}
+		m.log.Info("shutting down", "delay", delay.String(), "drain_timeout", m.cfg.DrainTimeout.String())
+		time.Sleep(delay)
+
+		ctx, cancel := m.drainContext()
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for i, s := range servers {
+			i, s := i, s
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.Shutdown(ctx); err != nil {
+					// drop the connections still busy after the timeout
+					s.Close()
+					errs[i] = err
+				}
+			}()
+		}
+		wg.Wait()
+		cancel()
+		for _, err := range errs {
+			if err != nil {
+				m.log.Error("failed to drain requests", "err", err)
+				record(err)
+			}
+		}
+	}
+
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		ctx, cancel := m.drainContext()
+		err := c.stop(ctx)
+		cancel()
+		if err != nil {
+			m.log.Error("failed to stop", "component", c.name, "err", err)
+			record(err)
+			continue
+		}
+		m.log.Debug("stopped", "component", c.name)
+	}
+	return first
+}
+
+func (m *Manager) drainContext() (context.Context, context.CancelFunc) {
+	if m.cfg.DrainTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), m.cfg.DrainTimeout)
+}
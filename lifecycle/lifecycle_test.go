@@ -0,0 +1,91 @@
+package lifecycle_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/lifecycle"
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+func TestManager(t *testing.T) {
+	t.Parallel()
+
+	m := lifecycle.New(lifecycle.Config{ShutdownDelay: 50 * time.Millisecond, DrainTimeout: 5 * time.Second}, logger.New(ioutil.Discard, &logger.LevelVar{}))
+
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, name)
+	}
+	m.Defer("db", func(ctx context.Context) error {
+		record("db")
+		return nil
+	})
+	m.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		record("worker")
+		return ctx.Err()
+	})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+	m.AddServer(srv, func() error { return srv.Serve(ln) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(ctx) }()
+
+	resErr := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if string(body) != "done" {
+				t.Errorf("in-flight request got %q", body)
+			}
+		}
+		resErr <- err
+	}()
+	<-started
+
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal("not ready before shutdown, err =", err)
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Check(context.Background()); err != lifecycle.ErrShuttingDown {
+		t.Error("readiness did not fail during shutdown, err =", err)
+	}
+	close(release)
+
+	if err := <-resErr; err != nil {
+		t.Error("in-flight request was not drained, err =", err)
+	}
+	if err := <-runErr; err != nil {
+		t.Error("Run failed, err =", err)
+	}
+	if len(stopped) != 2 || stopped[0] != "worker" || stopped[1] != "db" {
+		t.Errorf("stopped in order %v, want [worker db]", stopped)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/lifecycle"
+	"github.com/TechBowl-japan/go-stations/logger"
+)
+
+func TestManagerRestart(t *testing.T) {
+	m := lifecycle.New(lifecycle.DefaultConfig(), logger.New(ioutil.Discard, &logger.LevelVar{}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	m.AddListener(srv, ln, srv.Serve)
+
+	attempts := make(chan int, 2)
+	n := 0
+	m.OnRestart(func(ctx context.Context) error {
+		n++
+		attempts <- n
+		if n == 1 {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(context.Background()) }()
+	// let Run subscribe to the signal
+	time.Sleep(50 * time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	<-attempts
+	select {
+	case err := <-runErr:
+		t.Fatal("stopped after a failed restart, err =", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal("not ready after a failed restart, err =", err)
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	<-attempts
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Error("Run failed after a restart, err =", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not stop after a restart")
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("still accepting after a restart")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package lifecycle
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals request a restart registered with OnRestart.
+var restartSignals = []os.Signal{syscall.SIGUSR2}
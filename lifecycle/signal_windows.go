@@ -0,0 +1,6 @@
+package lifecycle
+
+import "os"
+
+// restartSignals is empty as windows has no SIGUSR2.
+var restartSignals []os.Signal
@@ -0,0 +1,95 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables describing the listeners handed to a restarted
+// process: their number, starting at descriptor 3, and the addresses they
+// were opened for in the same order, comma separated.
+const (
+	HandoffFDsEnv   = "GO_STATIONS_LISTEN_FDS"
+	HandoffAddrsEnv = "GO_STATIONS_LISTEN_ADDRS"
+)
+
+// A filer is a listener whose socket can be passed to another process.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Files returns duplicates of the sockets of lns, to be passed to a child
+// process. The caller closes them once the child started.
+func Files(lns []*Listener) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(lns))
+	for _, ln := range lns {
+		fl, ok := ln.Listener.(filer)
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("listener: %s cannot be handed off", ln.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// HandoffEnv returns the environment telling a child process started with
+// the Files of lns to take them over.
+func HandoffEnv(lns []*Listener) []string {
+	specs := make([]string, len(lns))
+	for i, ln := range lns {
+		specs[i] = ln.Spec
+	}
+	return []string{
+		HandoffFDsEnv + "=" + strconv.Itoa(len(lns)),
+		HandoffAddrsEnv + "=" + strings.Join(specs, ","),
+	}
+}
+
+// handoffListeners returns the listeners handed by the process this one
+// restarted, keyed by address. The environment variables are unset.
+func handoffListeners() (map[string][]net.Listener, error) {
+	fds, addrs := os.Getenv(HandoffFDsEnv), os.Getenv(HandoffAddrsEnv)
+	os.Unsetenv(HandoffFDsEnv)
+	os.Unsetenv(HandoffAddrsEnv)
+	if fds == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	names := strings.Split(addrs, ",")
+	if err != nil || n != len(names) {
+		return nil, errors.New("listener: invalid handoff environment")
+	}
+	lns := make(map[string][]net.Listener, n)
+	for i, name := range names {
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, hlns := range lns {
+				for _, ln := range hlns {
+					ln.Close()
+				}
+			}
+			return nil, fmt.Errorf("listener: handed socket %s: %w", name, err)
+		}
+		lns[name] = append(lns[name], ln)
+	}
+	return lns, nil
+}
@@ -0,0 +1,193 @@
+// Package listener opens the sockets the server accepts connections on: TCP
+// addresses, Unix domain sockets and sockets passed by systemd socket
+// activation.
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Networks of an Addr.
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+)
+
+// An Addr is a parsed listen address.
+type Addr struct {
+	Network string
+	// Address is the host:port of a TCP socket, the path of a Unix socket,
+	// or the FileDescriptorName= of systemd sockets, empty for all of them.
+	Address string
+}
+
+// String returns a in the form accepted by ParseAddr.
+func (a Addr) String() string {
+	switch a.Network {
+	case NetworkUnix:
+		return "unix://" + a.Address
+	case NetworkSystemd:
+		if a.Address == "" {
+			return NetworkSystemd
+		}
+		return NetworkSystemd + ":" + a.Address
+	default:
+		return "tcp://" + a.Address
+	}
+}
+
+// ParseAddr parses a listen address, one of
+//
+//	host:port, tcp://host:port  a TCP socket
+//	unix:///path/to.sock        a Unix domain socket
+//	systemd, systemd:NAME       every socket passed by systemd, or those
+//	                            named NAME by FileDescriptorName=
+func ParseAddr(s string) (Addr, error) {
+	switch {
+	case strings.HasPrefix(s, "unix://"):
+		path := strings.TrimPrefix(s, "unix://")
+		if path == "" {
+			return Addr{}, fmt.Errorf("listener: %q has no socket path", s)
+		}
+		return Addr{Network: NetworkUnix, Address: path}, nil
+	case s == NetworkSystemd:
+		return Addr{Network: NetworkSystemd}, nil
+	case strings.HasPrefix(s, NetworkSystemd+":"):
+		return Addr{Network: NetworkSystemd, Address: strings.TrimPrefix(s, NetworkSystemd+":")}, nil
+	}
+	hostport := strings.TrimPrefix(s, "tcp://")
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return Addr{}, fmt.Errorf("listener: %q: %w", s, err)
+	}
+	return Addr{Network: NetworkTCP, Address: hostport}, nil
+}
+
+// A Listener is a net.Listener opened for one of the addresses given to
+// Listen. The systemd addresses may open several.
+type Listener struct {
+	net.Listener
+	// Spec is the address as given to Listen.
+	Spec string
+}
+
+// Listen opens the listeners of every address in addrs, taking over those
+// handed by the process this one restarted. Unix sockets get the permissions
+// in unixMode. On failure, the listeners already opened are closed.
+func Listen(addrs []string, unixMode os.FileMode) (lns []*Listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			lns = nil
+		}
+	}()
+
+	handed, err := handoffListeners()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the addresses no longer configured are not served
+		for _, hlns := range handed {
+			for _, ln := range hlns {
+				ln.Close()
+			}
+		}
+	}()
+
+	var inherited []*systemdListener
+	defer func() {
+		// sockets passed by systemd but not asked for are not served
+		for _, sl := range inherited {
+			if !sl.used {
+				sl.ln.Close()
+			}
+		}
+	}()
+
+	for _, s := range addrs {
+		addr, err := ParseAddr(s)
+		if err != nil {
+			return lns, err
+		}
+		if hlns, ok := handed[s]; ok {
+			delete(handed, s)
+			for _, ln := range hlns {
+				if ul, ok := ln.(*net.UnixListener); ok && addr.Network == NetworkUnix {
+					// the socket file is ours to remove again
+					ul.SetUnlinkOnClose(true)
+				}
+				lns = append(lns, &Listener{Listener: ln, Spec: s})
+			}
+			continue
+		}
+		switch addr.Network {
+		case NetworkTCP:
+			ln, err := net.Listen("tcp", addr.Address)
+			if err != nil {
+				return lns, err
+			}
+			lns = append(lns, &Listener{Listener: ln, Spec: s})
+		case NetworkUnix:
+			ln, err := listenUnix(addr.Address, unixMode)
+			if err != nil {
+				return lns, err
+			}
+			lns = append(lns, &Listener{Listener: ln, Spec: s})
+		case NetworkSystemd:
+			if inherited == nil {
+				if inherited, err = systemdListeners(); err != nil {
+					return lns, err
+				}
+			}
+			found := false
+			for _, sl := range inherited {
+				if !sl.used && (addr.Address == "" || sl.name == addr.Address) {
+					sl.used, found = true, true
+					lns = append(lns, &Listener{Listener: sl.ln, Spec: s})
+				}
+			}
+			if !found {
+				return lns, fmt.Errorf("listener: no socket passed by systemd for %q", s)
+			}
+		}
+	}
+	return lns, nil
+}
+
+// listenUnix listens on the Unix socket at path with mode. A socket file left
+// behind by a process that is gone is removed; one still accepting
+// connections is an error.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("listener: %s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listener: %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
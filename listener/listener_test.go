@@ -0,0 +1,171 @@
+package listener_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/listener"
+)
+
+func TestParseAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		in      string
+		want    listener.Addr
+		wantErr bool
+	}{
+		"port":         {in: ":8080", want: listener.Addr{Network: listener.NetworkTCP, Address: ":8080"}},
+		"tcp":          {in: "tcp://127.0.0.1:8080", want: listener.Addr{Network: listener.NetworkTCP, Address: "127.0.0.1:8080"}},
+		"unix":         {in: "unix:///run/todo.sock", want: listener.Addr{Network: listener.NetworkUnix, Address: "/run/todo.sock"}},
+		"systemd":      {in: "systemd", want: listener.Addr{Network: listener.NetworkSystemd}},
+		"systemd name": {in: "systemd:web", want: listener.Addr{Network: listener.NetworkSystemd, Address: "web"}},
+		"no port":      {in: "localhost", wantErr: true},
+		"no path":      {in: "unix://", wantErr: true},
+	}
+	for name, tc := range tests {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := listener.ParseAddr(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// get requests / through ln, dialing with dial.
+func get(t *testing.T, dial func() (net.Conn, error)) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) { return dial() },
+	}}
+	res, err := client.Get("http://listener/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix socket permissions are not supported on windows")
+	}
+
+	sock := filepath.Join(t.TempDir(), "todo.sock")
+	lns, err := listener.Listen([]string{"127.0.0.1:0", "unix://" + sock}, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	for _, ln := range lns {
+		go srv.Serve(ln)
+	}
+
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+	if got := get(t, func() (net.Conn, error) { return net.Dial("tcp", lns[0].Addr().String()) }); got != "ok" {
+		t.Errorf("tcp got %q", got)
+	}
+	if got := get(t, func() (net.Conn, error) { return net.Dial("unix", sock) }); got != "ok" {
+		t.Errorf("unix got %q", got)
+	}
+
+	if _, err := listener.Listen([]string{"unix://" + sock}, 0o600); err == nil {
+		t.Error("listening on a socket in use succeeded")
+	}
+
+	// a socket left behind by a crashed process is replaced
+	lns[1].Listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	srv.Close()
+	again, err := listener.Listen([]string{"unix://" + sock}, 0o600)
+	if err != nil {
+		t.Fatal("listening on a stale socket failed, err =", err)
+	}
+	again[0].Close()
+}
+
+func TestListenSystemd(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not supported on windows")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// LISTEN_PID must name the process being activated, known only by the
+	// shell before it execs the helper
+	cmd := exec.Command("sh", "-c", `LISTEN_PID=$$ exec "$0" -test.run='^TestSystemdHelper$'`, os.Args[0])
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1", "LISTEN_FDNAMES=web", "GO_STATIONS_SYSTEMD_HELPER=1")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// only the helper accepts on the socket from now on
+	ln.Close()
+
+	if got := get(t, func() (net.Conn, error) { return net.Dial("tcp", ln.Addr().String()) }); got != "inherited" {
+		t.Errorf("got %q, want inherited", got)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Error("helper failed, err =", err)
+	}
+}
+
+// TestSystemdHelper serves one request on the socket passed by
+// TestListenSystemd.
+func TestSystemdHelper(t *testing.T) {
+	if os.Getenv("GO_STATIONS_SYSTEMD_HELPER") == "" {
+		t.Skip("run by TestListenSystemd")
+	}
+	lns, err := listener.Listen([]string{"systemd:web"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS was not unset")
+	}
+	done := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte("inherited"))
+		close(done)
+	})}
+	go srv.Serve(lns[0])
+	<-done
+	srv.Shutdown(context.Background())
+}
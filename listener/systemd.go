@@ -0,0 +1,63 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+type systemdListener struct {
+	ln   net.Listener
+	name string
+	used bool
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// as described in sd_listen_fds(3). The environment variables are unset so
+// that child processes do not take them for their own.
+func systemdListeners() ([]*systemdListener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds == "" {
+		return nil, fmt.Errorf("listener: no socket passed by systemd, LISTEN_FDS is not set")
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("listener: sockets were passed to process %s, not %d", pid, os.Getpid())
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("listener: invalid LISTEN_FDS %q", fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	sls := make([]*systemdListener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		// FileListener duplicates the descriptor
+		f.Close()
+		if err != nil {
+			for _, sl := range sls {
+				sl.ln.Close()
+			}
+			return nil, fmt.Errorf("listener: socket %s: %w", name, err)
+		}
+		sls = append(sls, &systemdListener{ln: ln, name: name})
+	}
+	return sls, nil
+}
@@ -0,0 +1,199 @@
+// Package logger implements leveled logging with JSON output. A Logger
+// carrying request scoped fields travels in the request context.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Level expresses the severity of a log entry.
+type Level int32
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+}
+
+// ParseLevel parses the names returned by Level.String.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("logger: unknown level %q", s)
+	}
+}
+
+// A LevelVar is a Level that can be changed while loggers use it.
+type LevelVar struct {
+	v int32
+}
+
+// Level returns the current level.
+func (v *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&v.v))
+}
+
+// Set changes the level of every logger sharing v.
+func (v *LevelVar) Set(l Level) {
+	atomic.StoreInt32(&v.v, int32(l))
+}
+
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// A Logger writes JSON entries at or above its level. Fields added with
+// With are included in every entry. Field values of type func() string are
+// evaluated when the entry is written and omitted when empty.
+type Logger struct {
+	out    *output
+	level  *LevelVar
+	fields []interface{}
+}
+
+// New returns a Logger writing to w with the level held by level.
+func New(w io.Writer, level *LevelVar) *Logger {
+	return &Logger{out: &output{w: w}, level: level}
+}
+
+// With returns a Logger that adds the key-value pairs to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, level: l.level, fields: fields}
+}
+
+// Level returns the variable holding the level of l.
+func (l *Logger) Level() *LevelVar {
+	return l.level
+}
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level.Level()
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+	writeFields(&buf, l.fields)
+	writeFields(&buf, kv)
+	buf.WriteString("}\n")
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func writeFields(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "!MISSING"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		switch v := value.(type) {
+		case func() string:
+			if value = v(); value == "" {
+				continue
+			}
+		case error:
+			value = v.Error()
+		}
+		buf.WriteByte(',')
+		writeValue(buf, key)
+		buf.WriteByte(':')
+		writeValue(buf, value)
+	}
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, &LevelVar{})
+)
+
+// Default returns the Logger used outside of requests.
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Logger returned by Default.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of parent that carries l.
+func NewContext(parent context.Context, l *Logger) context.Context {
+	return context.WithValue(parent, contextKey{}, l)
+}
+
+// FromContext returns the Logger carried by ctx, or Default.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
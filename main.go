@@ -2,92 +2,407 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"time"
 
+	"github.com/TechBowl-japan/go-stations/certs"
+	"github.com/TechBowl-japan/go-stations/config"
 	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/handler"
 	"github.com/TechBowl-japan/go-stations/handler/middleware"
 	"github.com/TechBowl-japan/go-stations/handler/router"
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/lifecycle"
+	"github.com/TechBowl-japan/go-stations/listener"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/restart"
 	"github.com/TechBowl-japan/go-stations/service"
+	"github.com/TechBowl-japan/go-stations/tracing"
 	"github.com/joho/godotenv"
 	"github.com/justinas/alice"
 )
 
 func main() {
-	err := realMain()
+	err := realMain(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalln("main: failed to exit successfully, err =", err)
+		logger.Default().Error("main: failed to exit successfully", "err", err)
+		os.Exit(1)
 	}
 }
 
-func realMain() error {
-	// config values
-	const (
-		defaultPort   = ":8080"
-		defaultDBPath = ".sqlite3/todo.db"
-	)
+func realMain(args []string) error {
+	// a missing .env is fine; the environment may be set by other means
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		cfg, err := config.Load(args[2:], os.Getenv)
+		if err != nil {
+			return err
+		}
+		return cfg.Print(os.Stdout)
+	}
+
+	cfg, err := config.Load(args, os.Getenv)
+	if err != nil {
+		return err
+	}
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatal(err)
+	// the level can be changed at runtime through /admin/log-level
+	logLevel, err := logger.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return err
 	}
+	level := &logger.LevelVar{}
+	level.Set(logLevel)
+	log := logger.New(os.Stderr, level)
+	logger.SetDefault(log)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	lockoutCfg := middleware.LockoutConfig{
+		UserMaxFailures: cfg.Auth.MaxFailures,
+		IPMaxFailures:   cfg.Auth.MaxFailuresPerIP,
+		LockoutDuration: cfg.Auth.LockoutDuration,
+		BaseDelay:       cfg.Auth.BaseDelay,
+		MaxDelay:        cfg.Auth.MaxDelay,
+		Window:          cfg.Auth.FailureWindow,
 	}
 
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = defaultDBPath
+	realIP, err := middleware.NewRealIP(cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
 	}
 
+	limitTODOs, err := middleware.ParseRateLimit(cfg.RateLimit.TODOs)
+	if err != nil {
+		return err
+	}
+	limitLists, err := middleware.ParseRateLimit(cfg.RateLimit.Lists)
+	if err != nil {
+		return err
+	}
+	limitIP, err := middleware.ParseRateLimit(cfg.RateLimit.IP)
+	if err != nil {
+		return err
+	}
+
+	// everything registered to mgr is released in reverse order on return
+	mgr := lifecycle.New(lifecycle.Config{
+		ShutdownDelay: cfg.Shutdown.Delay,
+		DrainTimeout:  cfg.Shutdown.Timeout,
+	}, log)
+	defer mgr.Stop()
+
+	accessLogSink, accessLogFile, err := middleware.OpenAccessLogSink(cfg.Log.AccessLogFormat, cfg.Log.AccessLogFile)
+	if err != nil {
+		return err
+	}
+	mgr.Defer("access_log", func(context.Context) error { return accessLogFile.Close() })
+
+	tracer, err := newTracer(&cfg.Trace)
+	if err != nil {
+		return err
+	}
+	mgr.Defer("tracer", tracer.Shutdown)
+
 	// set time zone
-	var err error
-	time.Local, err = time.LoadLocation("Asia/Tokyo")
+	time.Local, err = time.LoadLocation(cfg.Timezone)
 	if err != nil {
 		return err
 	}
 
 	// set up sqlite3
-	todoDB, err := db.NewDB(dbPath)
+	todoDB, err := db.NewDB(cfg.DB.Path)
 	if err != nil {
 		return err
 	}
-	defer todoDB.Close()
+	mgr.Defer("db", func(context.Context) error { return todoDB.Close() })
+
+	// readiness checks of the dependencies
+	checks := health.New(0)
+	checks.Register("lifecycle", mgr)
+	checks.Register("db", health.DBPing(todoDB))
+	checks.Register("migrations", health.Migrations(todoDB))
+	checks.Register("disk", health.DiskSpace(filepath.Dir(cfg.DB.Path), cfg.Readiness.MinFreeDisk))
+
+	// Basic credentials come from an htpasswd file when configured
+	basicAuth := middleware.NewBasicAuth(middleware.StaticCredentials{UserID: cfg.Auth.UserID, Password: cfg.Auth.Password})
+	if cfg.Auth.HtpasswdFile != "" {
+		htpasswd, err := middleware.NewHtpasswd(cfg.Auth.HtpasswdFile)
+		if err != nil {
+			return err
+		}
+		const watchInterval = 5 * time.Second
+		htpasswd.Heartbeat = health.NewHeartbeat(3 * watchInterval)
+		checks.Register("htpasswd_watcher", htpasswd.Heartbeat)
+		mgr.Go("htpasswd_watcher", func(ctx context.Context) error {
+			htpasswd.Watch(ctx, watchInterval)
+			return nil
+		})
+		basicAuth = middleware.NewBasicAuth(htpasswd)
+	}
 
 	// set http handlers
 	mux := router.NewRouter(todoDB)
 
 	// TODO: ここから実装を行う
-	logChain := alice.New(middleware.GetOS, middleware.GetAccessLog)
-	mux.Handle("/healthz", logChain.Then(handler.NewHealthzHandler()))
-	hTODO := handler.NewTODOHandler(service.NewTODOService(todoDB))
-	mux.Handle("/todos", logChain.Append(middleware.BasicAuth).Then(hTODO))
+	registry := metrics.NewRegistry()
+	httpMetrics := middleware.NewMetrics(registry)
+	httpTracing := middleware.NewTracing(tracer)
+	handle := func(route string, chain alice.Chain, h http.Handler) {
+		mux.Handle(route, alice.New(httpMetrics.Route(route), httpTracing.Route(route)).Extend(chain).Then(h))
+	}
+
+	var panicReporter middleware.PanicReporter
+	if cfg.Sentry.DSN != "" {
+		if panicReporter, err = middleware.NewSentryReporter(cfg.Sentry.DSN, cfg.Sentry.Environment); err != nil {
+			return err
+		}
+	}
+	recovery := middleware.NewRecovery(panicReporter, registry)
+
+	logChain := alice.New(realIP, middleware.GetOS, middleware.RequestID, middleware.NewAccessLog(accessLogSink), middleware.NewRequestLogger(log), recovery, middleware.NewBodyLimit(cfg.Server.MaxBodyBytes))
+	if cfg.Server.Compress {
+		logChain = logChain.Append(middleware.NewCompress(cfg.Server.CompressMinSize))
+	}
+	authenticate := middleware.NewLockout(lockoutCfg).Wrap(basicAuth)
+	if cfg.TLS.ClientCAFile != "" {
+		// a verified client certificate authenticates on its own
+		certUser, err := middleware.ClientCertUserByField(cfg.TLS.ClientUser)
+		if err != nil {
+			return err
+		}
+		authenticate = middleware.NewClientCertAuth(certUser, authenticate)
+	}
+	// clients are limited before authentication too, so that failing it
+	// over and over does not cost password checks without bound
+	limitClients := middleware.NewRateLimiterByKey(limitIP, middleware.ClientIPKey).Handler
+	authChain := logChain.Append(limitClients, authenticate)
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		cors, err := middleware.NewCORS(cfg.CORS.Middleware())
+		if err != nil {
+			return err
+		}
+		// preflights carry no credentials and are answered before authentication
+		authChain = logChain.Append(cors, limitClients, authenticate)
+	}
+	idempotency := middleware.NewIdempotency(service.NewIdempotencyService(todoDB), cfg.Idempotency.TTL)
+	// commands sent over WebSocket share the budget of the REST endpoints
+	todoLimiter := middleware.NewRateLimiter(limitTODOs)
+	todoChain := authChain.Append(todoLimiter.Handler, idempotency, middleware.NewTimeout(cfg.Timeout.TODOs))
+	listChain := authChain.Append(middleware.NewRateLimiter(limitLists).Handler, middleware.NewTimeout(cfg.Timeout.Lists))
+	handle("/healthz", logChain, handler.NewHealthzHandler())
+	handle("/livez", logChain, handler.NewLivezHandler())
+	handle("/readyz", logChain, handler.NewReadyzHandler(checks))
+	todoSvc := service.NewTODOService(todoDB)
+	listSvc := service.NewListService(todoDB)
+	var todoStore service.TODOStore = todoSvc
+	if cfg.Cache.Enabled {
+		cached := service.NewCachedTODOService(todoSvc, service.TODOCacheConfig{Size: cfg.Cache.Size, TTL: cfg.Cache.TTL}, registry)
+		// members removed must not keep reading what they could before
+		listSvc.OnAccessChange(cached.Invalidate)
+		todoStore = cached
+	}
+	hTODO := handler.NewTODOHandler(todoStore)
+	handle("/todos", todoChain, hTODO)
+	handle("/todos/", todoChain, handler.NewTODOItemHandler(todoStore))
+	// streams outlive the route timeout
+	eventChain := authChain.Append(middleware.NewRateLimiter(limitTODOs).Handler)
+	eventSvc := service.NewTODOEventService(todoDB, service.TODOEventConfig{PollInterval: cfg.Events.PollInterval, Retention: cfg.Events.Retention})
+	eventSvc.Heartbeat = health.NewHeartbeat(3 * cfg.Events.PollInterval)
+	checks.Register("todo_events", eventSvc.Heartbeat)
+	mgr.Go("todo_events", eventSvc.Run)
+	handle("/todos/events", eventChain, handler.NewTODOEventHandler(eventSvc, cfg.Events.Heartbeat))
+	handle("/todos/ws", eventChain, handler.NewTODOSocketHandler(todoStore, eventSvc, handler.TODOSocketConfig{
+		Heartbeat: cfg.Events.Heartbeat,
+		MaxBytes:  cfg.Server.MaxBodyBytes,
+		Timeout:   cfg.Timeout.TODOs,
+		RateLimit: todoLimiter,
+	}))
+	handle("/lists", listChain, handler.NewListHandler(listSvc))
+	handle("/lists/members", listChain, handler.NewListMemberHandler(listSvc))
+	handle("/lists/invites", listChain, handler.NewListInviteHandler(listSvc))
+	handle("/lists/invites/accept", listChain, handler.NewListInviteAcceptHandler(listSvc))
+	webhookSvc := service.NewWebhookService(todoDB, eventSvc, service.WebhookConfig{
+		PollInterval: cfg.Events.PollInterval,
+		Timeout:      cfg.Webhooks.Timeout,
+		MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		Backoff:      cfg.Webhooks.Backoff,
+		MaxBackoff:   cfg.Webhooks.MaxBackoff,
+		DisableAfter: cfg.Webhooks.DisableAfter,
+		Concurrency:  cfg.Webhooks.Concurrency,
+		Retention:    cfg.Webhooks.Retention,
+
+		AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+	}, registry)
+	// a delivery attempt may take up to the timeout between beats
+	webhookSvc.Heartbeat = health.NewHeartbeat(3*cfg.Events.PollInterval + cfg.Webhooks.Timeout)
+	checks.Register("webhooks", webhookSvc.Heartbeat)
+	mgr.Go("webhooks", webhookSvc.Run)
+	// webhooks are managed as rarely as lists
+	handle("/webhooks", listChain, handler.NewWebhookHandler(webhookSvc))
+	handle("/webhooks/deliveries", listChain, handler.NewWebhookDeliveryHandler(webhookSvc))
+	adminChain := authChain.Append(middleware.NewRequireUsers(cfg.Auth.AdminUsers), middleware.NewTimeout(cfg.Timeout.Admin))
+	handle("/admin/log-level", adminChain, handler.NewLogLevelHandler(level))
 	hPanic := handler.NewPanicHandler()
-	mux.Handle("/do-panic", logChain.Append(middleware.Recovery).Then(hPanic))
+	handle("/do-panic", logChain, hPanic)
+
+	registry.MustRegister(
+		metrics.NewDBStats(todoDB),
+		metrics.NewCounterFunc("todos_created_total", "Number of TODOs ever created.", countTODOs(log, todoSvc.CountCreatedTODO)),
+		// TODOs are closed by deleting them
+		metrics.NewGaugeFunc("todos_open", "Number of TODOs created and not deleted yet.", countTODOs(log, todoSvc.CountTODO)),
+	)
+	// the metrics tell how many TODOs there are, so only admins may scrape them
+	handle("/metrics", adminChain, registry)
 	srv := &http.Server{
-		Addr:    port,
-		Handler: mux,
+		Addr:              cfg.Server.Addr,
+		Handler:           mux,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+	// event streams never go idle, so the drain would wait for them, and
+	// WebSocket connections are not drained at all
+	srv.RegisterOnShutdown(eventSvc.Close)
+
+	serve := srv.Serve
+	if cfg.TLS.CertFile != "" {
+		reloader, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		reloader.Heartbeat = health.NewHeartbeat(3 * cfg.TLS.ReloadInterval)
+		checks.Register("cert_watcher", reloader.Heartbeat)
+		mgr.Go("cert_watcher", func(ctx context.Context) error {
+			reloader.Watch(ctx, cfg.TLS.ReloadInterval)
+			return nil
+		})
+		var clientCAs *x509.CertPool
+		if cfg.TLS.ClientCAFile != "" {
+			if clientCAs, err = certs.LoadCertPool(cfg.TLS.ClientCAFile); err != nil {
+				return err
+			}
+		}
+		srv.TLSConfig = certs.ServerConfig(reloader, clientCAs, cfg.TLS.ClientAuth)
+		serve = func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }
+	}
+
+	socketMode, err := cfg.Server.SocketMode()
+	if err != nil {
+		return err
+	}
+	addrs := cfg.Server.ListenAddrs()
+	if cfg.TLS.RedirectAddr != "" {
+		addrs = append(addrs, cfg.TLS.RedirectAddr)
+	}
+	// sockets handed over by a restart are taken over rather than bound
+	listeners, err := listener.Listen(addrs, socketMode)
+	if err != nil {
+		return err
+	}
+	redirect := &http.Server{
+		Handler:           handler.NewHTTPSRedirectHandler(httpsAddr(listeners, cfg.TLS.RedirectAddr)),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+	for _, ln := range listeners {
+		if cfg.TLS.RedirectAddr != "" && ln.Spec == cfg.TLS.RedirectAddr {
+			mgr.AddListener(redirect, ln, redirect.Serve)
+			continue
+		}
+		mgr.AddListener(srv, ln, serve)
 	}
 
-	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
-	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalln("Server closed with error:", err)
+	mgr.OnRestart(func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Shutdown.RestartTimeout)
+		defer cancel()
+		return restart.Start(ctx, listeners)
+	})
+	mgr.Go("restart_ready", func(ctx context.Context) error {
+		// a process started by a restart replaces its parent once ready
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if _, ok := checks.Check(ctx); ok {
+				if err := restart.Ready(); err != nil {
+					log.Error("failed to report ready to the previous process", "err", err)
+				}
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
 		}
-	}()
+	})
 
-	<-ctx.Done()
+	return mgr.Run(context.Background())
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal(err)
+// newTracer returns a tracer exporting as cfg tells: "json" writes spans to
+// the file or stdout, "otlp" posts them to the collector, and empty only
+// propagates trace context.
+func newTracer(cfg *config.TraceConfig) (*tracing.Tracer, error) {
+	switch cfg.Exporter {
+	case "":
+		return tracing.NewTracer(nil), nil
+	case "json":
+		exp, err := tracing.OpenJSONExporter(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		return tracing.NewTracer(exp), nil
+	case "otlp":
+		return tracing.NewTracer(tracing.NewOTLPExporter(cfg.OTLPEndpoint, cfg.ServiceName, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
 	}
+}
 
-	return nil
+// countTODOs returns the value of a TODO metric counted by count on every
+// scrape, NaN when counting failed.
+func countTODOs(log *logger.Logger, count func(ctx context.Context) (int64, error)) func() float64 {
+	return func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := count(ctx)
+		if err != nil {
+			log.Error("failed to count todos", "err", err)
+			return math.NaN()
+		}
+		return float64(n)
+	}
+}
+
+// httpsAddr returns the address of the first TCP socket served over HTTPS
+// among listeners, as bound rather than as configured, or an empty string
+// when HTTPS is served on other sockets only.
+func httpsAddr(listeners []*listener.Listener, redirectSpec string) string {
+	for _, ln := range listeners {
+		if ln.Spec == redirectSpec {
+			continue
+		}
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+			return addr.String()
+		}
+	}
+	return ""
 }
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// A DBStats exposes the connection pool statistics of a sql.DB.
+type DBStats struct {
+	db *sql.DB
+}
+
+// NewDBStats returns a Collector of the pool statistics of db.
+func NewDBStats(db *sql.DB) *DBStats {
+	return &DBStats{db: db}
+}
+
+// Write implements Collector interface.
+func (c *DBStats) Write(w io.Writer) error {
+	s := c.db.Stats()
+	families := []struct {
+		name, help, typ string
+		value           float64
+	}{
+		{"db_max_open_connections", "Maximum number of open connections to the database.", "gauge", float64(s.MaxOpenConnections)},
+		{"db_open_connections", "The number of established connections both in use and idle.", "gauge", float64(s.OpenConnections)},
+		{"db_in_use_connections", "The number of connections currently in use.", "gauge", float64(s.InUse)},
+		{"db_idle_connections", "The number of idle connections.", "gauge", float64(s.Idle)},
+		{"db_wait_count_total", "The total number of connections waited for.", "counter", float64(s.WaitCount)},
+		{"db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", "counter", s.WaitDuration.Seconds()},
+		{"db_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", "counter", float64(s.MaxIdleClosed)},
+		{"db_max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime.", "counter", float64(s.MaxIdleTimeClosed)},
+		{"db_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", "counter", float64(s.MaxLifetimeClosed)},
+	}
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", f.name, f.help, f.name, f.typ, f.name, formatFloat(f.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Package metrics implements counters, gauges and histograms exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the latency buckets in seconds used by Prometheus clients.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Collector writes one metric family in the text exposition format.
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+// A Registry exposes the registered collectors on ServeHTTP.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// MustRegister adds collectors to r.
+func (r *Registry) MustRegister(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// Gather writes every collector to w.
+func (r *Registry) Gather(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.collectors {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler interface.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	if err := r.Gather(bw); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	bw.Flush()
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+	return err
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs renders the labels for key, with extra appended.
+func (d *desc) labelPairs(key string, extra ...string) string {
+	var values []string
+	if len(d.labels) > 0 {
+		values = strings.Split(key, "\xff")
+	}
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// A Vec holds one value per combination of label values. It backs counters and gauges.
+type Vec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec returns a monotonically increasing metric.
+func NewCounterVec(name, help string, labels ...string) *Vec {
+	return &Vec{desc: desc{name: name, help: help, typ: "counter", labels: labels}, values: map[string]float64{}}
+}
+
+// NewGaugeVec returns a metric that can go up and down.
+func NewGaugeVec(name, help string, labels ...string) *Vec {
+	return &Vec{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, values: map[string]float64{}}
+}
+
+// Add adds delta to the value for the label values.
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+// Inc adds one to the value for the label values.
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the value for the label values.
+func (v *Vec) Dec(labelValues ...string) {
+	v.Add(-1, labelValues...)
+}
+
+// Set replaces the value for the label values.
+func (v *Vec) Set(value float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = value
+}
+
+// Value returns the value for the label values.
+func (v *Vec) Value(labelValues ...string) float64 {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[key]
+}
+
+// Write implements Collector interface.
+func (v *Vec) Write(w io.Writer) error {
+	v.mu.Lock()
+	keys := sortedKeys(v.values)
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = v.values[k]
+	}
+	v.mu.Unlock()
+
+	if err := v.header(w); err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(k), formatFloat(values[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// A HistogramVec counts observations in buckets per combination of label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+// NewHistogramVec returns a histogram with the given upper bounds.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &HistogramVec{desc: desc{name: name, help: help, typ: "histogram", labels: labels}, buckets: b, values: map[string]*histogramValue{}}
+}
+
+// Observe records value for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += value
+	hv.count++
+}
+
+// Write implements Collector interface.
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.header(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		for i, upper := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", formatFloat(upper)), hv.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelPairs(k, "le", "+Inf"), hv.count,
+			h.name, h.labelPairs(k), formatFloat(hv.sum),
+			h.name, h.labelPairs(k), hv.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Func reports a value computed when the metrics are scraped.
+type Func struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc returns a gauge whose value is fn().
+func NewGaugeFunc(name, help string, fn func() float64) *Func {
+	return &Func{desc: desc{name: name, help: help, typ: "gauge"}, fn: fn}
+}
+
+// NewCounterFunc returns a counter whose value is fn(). fn must never decrease.
+func NewCounterFunc(name, help string, fn func() float64) *Func {
+	return &Func{desc: desc{name: name, help: help, typ: "counter"}, fn: fn}
+}
+
+// Write implements Collector interface.
+func (f *Func) Write(w io.Writer) error {
+	if err := f.header(w); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+	return err
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpReplacer.Replace(s) }
+func escapeLabel(s string) string { return labelReplacer.Replace(s) }
@@ -0,0 +1,92 @@
+package metrics_test
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	requests := metrics.NewCounterVec("http_requests_total", "Requests served.", "route", "code")
+	requests.Inc("/todos", "200")
+	requests.Add(2, "/todos", "200")
+	requests.Inc("/lists", "404")
+	// label values are escaped, help too
+	escaped := metrics.NewGaugeVec("escaped", "Back\\slash and\nnewline.", "value")
+	escaped.Set(-1.5, "a\"b\\c\nd")
+	latency := metrics.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 5} {
+		latency.Observe(v, "/todos")
+	}
+	unlabeled := metrics.NewGaugeVec("in_flight", "In flight.")
+	unlabeled.Inc()
+	unlabeled.Inc()
+	unlabeled.Dec()
+
+	reg := metrics.NewRegistry()
+	reg.MustRegister(
+		requests,
+		escaped,
+		latency,
+		unlabeled,
+		metrics.NewCounterFunc("created_total", "Created.", func() float64 { return 1e6 }),
+		metrics.NewGaugeFunc("broken", "Failed to compute.", math.NaN),
+		metrics.NewGaugeFunc("unbounded", "Infinite.", func() float64 { return math.Inf(1) }),
+	)
+
+	want := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{route="/lists",code="404"} 1
+http_requests_total{route="/todos",code="200"} 3
+# HELP escaped Back\\slash and\nnewline.
+# TYPE escaped gauge
+escaped{value="a\"b\\c\nd"} -1.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/todos",le="0.1"} 2
+latency_seconds_bucket{route="/todos",le="1"} 3
+latency_seconds_bucket{route="/todos",le="+Inf"} 4
+latency_seconds_sum{route="/todos"} 5.65
+latency_seconds_count{route="/todos"} 4
+# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP created_total Created.
+# TYPE created_total counter
+created_total 1e+06
+# HELP broken Failed to compute.
+# TYPE broken gauge
+broken NaN
+# HELP unbounded Infinite.
+# TYPE unbounded gauge
+unbounded +Inf
+`
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text format", got)
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVecLabelCount(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("missing label values did not panic")
+		}
+	}()
+	metrics.NewCounterVec("requests_total", "Requests.", "route", "code").Inc("/todos")
+}
@@ -7,6 +7,16 @@ import (
 type AccessLog struct {
 	Timestamp time.Time `json:"timestamp"`
 	Latency   int64     `json:"latency"`
+	Method    string    `json:"method"`
 	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	RemoteIP  string    `json:"remote_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	User      string    `json:"user,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
 	OS        string    `json:"os"`
 }
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// An AuditLog expresses a security relevant event.
+type AuditLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
+	User      string    `json:"user,omitempty"`
+	RemoteIP  string    `json:"remote_ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
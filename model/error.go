@@ -8,8 +8,36 @@ type (
 	ErrNotFound struct {
 		RowIDs []int64
 	}
+
+	ErrForbidden struct {
+		ListID int64
+	}
+
+	ErrInviteExpired struct {
+		Token string
+	}
+
+	ErrInvalidWebhookURL struct {
+		URL    string
+		Reason string
+	}
 )
 
 func (e *ErrNotFound) Error() string {
 	return fmt.Sprintf("The row with id(s) %v was not found", e.RowIDs)
 }
+
+func (e *ErrForbidden) Error() string {
+	if e.ListID == 0 {
+		return "The TODO without an owner is read-only"
+	}
+	return fmt.Sprintf("The list with id %d does not grant the required permission", e.ListID)
+}
+
+func (e *ErrInviteExpired) Error() string {
+	return "The invite has expired or does not exist"
+}
+
+func (e *ErrInvalidWebhookURL) Error() string {
+	return fmt.Sprintf("The webhook URL %q %s", e.URL, e.Reason)
+}
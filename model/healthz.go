@@ -4,3 +4,17 @@ package model
 type HealthzResponse struct {
 	Message string `json:"message"`
 }
+
+// A HealthCheck expresses the result of one readiness check.
+type HealthCheck struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// A HealthResponse expresses the result of the liveness or readiness probe.
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+// An IdempotencyRecord expresses a request made with an Idempotency-Key and
+// the response recorded for it. StatusCode is zero while the first request
+// is still being processed.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"time"
+)
+
+// ListPermission expresses what a member may do with a shared list.
+type ListPermission string
+
+const (
+	// PermissionViewer may read the TODOs of the list.
+	PermissionViewer ListPermission = "viewer"
+	// PermissionEditor may additionally create, update and delete TODOs.
+	PermissionEditor ListPermission = "editor"
+	// PermissionOwner may additionally manage the list, its members and invites.
+	PermissionOwner ListPermission = "owner"
+)
+
+// Valid reports whether p is a known permission.
+func (p ListPermission) Valid() bool {
+	return p.rank() > 0
+}
+
+// Allows reports whether p grants at least the required permission.
+func (p ListPermission) Allows(required ListPermission) bool {
+	return p.Valid() && p.rank() >= required.rank()
+}
+
+func (p ListPermission) rank() int {
+	switch p {
+	case PermissionViewer:
+		return 1
+	case PermissionEditor:
+		return 2
+	case PermissionOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+type (
+	// A List expresses a shareable group of TODOs.
+	List struct {
+		ID         int64          `json:"id"`
+		Name       string         `json:"name"`
+		Permission ListPermission `json:"permission,omitempty"`
+		CreatedAt  time.Time      `json:"created_at"`
+		UpdatedAt  time.Time      `json:"updated_at"`
+	}
+
+	// A ListMember expresses a user that can access a List.
+	ListMember struct {
+		ListID     int64          `json:"list_id"`
+		UserID     string         `json:"user_id"`
+		Permission ListPermission `json:"permission"`
+		CreatedAt  time.Time      `json:"created_at"`
+	}
+
+	// A ListInvite expresses a token that grants membership of a List until it expires.
+	ListInvite struct {
+		Token      string         `json:"token"`
+		ListID     int64          `json:"list_id"`
+		Permission ListPermission `json:"permission"`
+		CreatedBy  string         `json:"created_by"`
+		ExpiresAt  time.Time      `json:"expires_at"`
+		CreatedAt  time.Time      `json:"created_at"`
+	}
+
+	// A CreateListRequest expresses ...
+	CreateListRequest struct {
+		Name string `json:"name"`
+	}
+	// A CreateListResponse expresses ...
+	CreateListResponse struct {
+		List List `json:"list"`
+	}
+
+	// A ReadListResponse expresses ...
+	ReadListResponse struct {
+		Lists []*List `json:"lists"`
+	}
+
+	// A UpdateListRequest expresses ...
+	UpdateListRequest struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	// A UpdateListResponse expresses ...
+	UpdateListResponse struct {
+		List List `json:"list"`
+	}
+
+	// A DeleteListRequest expresses ...
+	DeleteListRequest struct {
+		ID int64 `json:"id"`
+	}
+	// A DeleteListResponse expresses ...
+	DeleteListResponse struct {
+	}
+
+	// A ReadListMemberResponse expresses ...
+	ReadListMemberResponse struct {
+		Members []*ListMember `json:"members"`
+	}
+
+	// A PutListMemberRequest expresses ...
+	PutListMemberRequest struct {
+		ListID     int64          `json:"list_id"`
+		UserID     string         `json:"user_id"`
+		Permission ListPermission `json:"permission"`
+	}
+	// A PutListMemberResponse expresses ...
+	PutListMemberResponse struct {
+		Member ListMember `json:"member"`
+	}
+
+	// A DeleteListMemberRequest expresses ...
+	DeleteListMemberRequest struct {
+		ListID int64  `json:"list_id"`
+		UserID string `json:"user_id"`
+	}
+	// A DeleteListMemberResponse expresses ...
+	DeleteListMemberResponse struct {
+	}
+
+	// A CreateListInviteRequest expresses ...
+	CreateListInviteRequest struct {
+		ListID     int64          `json:"list_id"`
+		Permission ListPermission `json:"permission"`
+		TTLSeconds int64          `json:"ttl_seconds"`
+	}
+	// A CreateListInviteResponse expresses ...
+	CreateListInviteResponse struct {
+		Invite ListInvite `json:"invite"`
+	}
+
+	// A ReadListInviteResponse expresses ...
+	ReadListInviteResponse struct {
+		Invites []*ListInvite `json:"invites"`
+	}
+
+	// A DeleteListInviteRequest expresses ...
+	DeleteListInviteRequest struct {
+		Token string `json:"token"`
+	}
+	// A DeleteListInviteResponse expresses ...
+	DeleteListInviteResponse struct {
+	}
+
+	// A AcceptListInviteRequest expresses ...
+	AcceptListInviteRequest struct {
+		Token string `json:"token"`
+	}
+	// A AcceptListInviteResponse expresses ...
+	AcceptListInviteResponse struct {
+		Member ListMember `json:"member"`
+	}
+)
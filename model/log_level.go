@@ -0,0 +1,12 @@
+package model
+
+type (
+	// A LogLevelRequest expresses ...
+	LogLevelRequest struct {
+		Level string `json:"level"`
+	}
+	// A LogLevelResponse expresses ...
+	LogLevelResponse struct {
+		Level string `json:"level"`
+	}
+)
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// A PanicReport expresses a panic recovered while serving a request.
+type PanicReport struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     string    `json:"value"`
+	Stack     string    `json:"stack"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	User      string    `json:"user,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+}
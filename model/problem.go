@@ -0,0 +1,12 @@
+package model
+
+// A Problem expresses an error response body as defined in RFC 7807.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// RequestID is an extension member correlating the problem with logs.
+	RequestID string `json:"request_id,omitempty"`
+}
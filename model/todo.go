@@ -10,14 +10,25 @@ type (
 		ID          int64     `json:"id"`
 		Subject     string    `json:"subject"`
 		Description string    `json:"description"`
+		Owner       string    `json:"owner,omitempty"`
+		ListID      int64     `json:"list_id,omitempty"`
 		CreatedAt   time.Time `json:"created_at"`
 		UpdatedAt   time.Time `json:"updated_at"`
 	}
 
+	// A TODOVersion expresses the revision of a TODO, which changes whenever
+	// the TODO is updated.
+	TODOVersion struct {
+		ID        int64
+		Version   int64
+		UpdatedAt time.Time
+	}
+
 	// A CreateTODORequest expresses ...
 	CreateTODORequest struct {
 		Subject     string `json:"subject"`
 		Description string `json:"description"`
+		ListID      int64  `json:"list_id"`
 	}
 	// A CreateTODOResponse expresses ...
 	CreateTODOResponse struct {
@@ -28,12 +39,18 @@ type (
 	ReadTODORequest struct {
 		PrevID int64 `json:"prev_id"`
 		Size   int64 `json:"size"`
+		ListID int64 `json:"list_id"`
 	}
 	// A ReadTODOResponse expresses ...
 	ReadTODOResponse struct {
 		TODOs []*TODO `json:"todos"`
 	}
 
+	// A GetTODOResponse expresses ...
+	GetTODOResponse struct {
+		TODO TODO `json:"todo"`
+	}
+
 	// A UpdateTODORequest expresses ...
 	UpdateTODORequest struct {
 		ID          int64  `json:"id"`
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+)
+
+// A TODOEventType tells what happened to a TODO.
+type TODOEventType string
+
+const (
+	TODOEventCreated TODOEventType = "created"
+	TODOEventUpdated TODOEventType = "updated"
+	TODOEventDeleted TODOEventType = "deleted"
+)
+
+// A TODOEvent expresses a change of a TODO, with the TODO as it was after
+// the change, or before its deletion.
+type TODOEvent struct {
+	ID        int64         `json:"id"`
+	Type      TODOEventType `json:"type"`
+	TODO      TODO          `json:"todo"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Valid reports whether t is a known event type.
+func (t TODOEventType) Valid() bool {
+	switch t {
+	case TODOEventCreated, TODOEventUpdated, TODOEventDeleted:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+// Types of the messages of the WebSocket API, sent by clients.
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketCreate      = "create"
+	SocketUpdate      = "update"
+	SocketDelete      = "delete"
+)
+
+// Types of the messages of the WebSocket API, sent by the server.
+const (
+	SocketResponse = "response"
+	SocketError    = "error"
+	SocketEvent    = "event"
+)
+
+type (
+	// A SocketRequest expresses a command sent over the WebSocket API. The
+	// payload of create, update and delete is the body of the REST request.
+	// ID is chosen by the client and repeated in the response.
+	SocketRequest struct {
+		ID      string          `json:"id"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	// A SocketMessage expresses a message sent by the server over the
+	// WebSocket API: the response to the request with ID, or an event of a
+	// subscribed list.
+	SocketMessage struct {
+		ID      string      `json:"id,omitempty"`
+		Type    string      `json:"type"`
+		Status  int         `json:"status,omitempty"`
+		Detail  string      `json:"detail,omitempty"`
+		Payload interface{} `json:"payload,omitempty"`
+	}
+
+	// A SubscribeRequest expresses ... A zero ListID subscribes to every
+	// TODO the user can read.
+	SubscribeRequest struct {
+		ListID int64 `json:"list_id"`
+	}
+	// A SubscribeResponse expresses ... Truncated tells the TODOs were cut
+	// at the message size limit, the older ones are read with GET /todos.
+	SubscribeResponse struct {
+		TODOs       []*TODO `json:"todos"`
+		LastEventID int64   `json:"last_event_id"`
+		Truncated   bool    `json:"truncated,omitempty"`
+	}
+
+	// A UnsubscribeRequest expresses ...
+	UnsubscribeRequest struct {
+		ListID int64 `json:"list_id"`
+	}
+	// A UnsubscribeResponse expresses ...
+	UnsubscribeResponse struct {
+	}
+)
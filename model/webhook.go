@@ -0,0 +1,91 @@
+package model
+
+import (
+	"time"
+)
+
+// A WebhookDeliveryStatus tells where a delivery is in its retries.
+type WebhookDeliveryStatus string
+
+const (
+	// DeliveryPending is waiting for its next attempt.
+	DeliveryPending WebhookDeliveryStatus = "pending"
+	// DeliverySucceeded was answered with a 2xx status.
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	// DeliveryFailed ran out of attempts.
+	DeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+type (
+	// A Webhook expresses a URL posted the TODO events of Events, all of them
+	// when empty, that its owner can read. Secret is only told on creation.
+	Webhook struct {
+		ID         int64           `json:"id"`
+		URL        string          `json:"url"`
+		Secret     string          `json:"secret,omitempty"`
+		Events     []TODOEventType `json:"events"`
+		Active     bool            `json:"active"`
+		Failures   int             `json:"failures"`
+		DisabledAt *time.Time      `json:"disabled_at,omitempty"`
+		CreatedAt  time.Time       `json:"created_at"`
+		UpdatedAt  time.Time       `json:"updated_at"`
+	}
+
+	// A WebhookDelivery expresses the posting of an event to a Webhook, with
+	// the outcome of its last attempt.
+	WebhookDelivery struct {
+		ID             int64                 `json:"id"`
+		WebhookID      int64                 `json:"webhook_id"`
+		EventID        int64                 `json:"event_id"`
+		EventType      TODOEventType         `json:"event_type"`
+		Status         WebhookDeliveryStatus `json:"status"`
+		Attempts       int                   `json:"attempts"`
+		ResponseStatus int                   `json:"response_status,omitempty"`
+		Error          string                `json:"error,omitempty"`
+		NextAttemptAt  time.Time             `json:"next_attempt_at"`
+		CreatedAt      time.Time             `json:"created_at"`
+		UpdatedAt      time.Time             `json:"updated_at"`
+	}
+
+	// A CreateWebhookRequest expresses ...
+	CreateWebhookRequest struct {
+		URL    string          `json:"url"`
+		Secret string          `json:"secret"`
+		Events []TODOEventType `json:"events"`
+	}
+	// A CreateWebhookResponse expresses ...
+	CreateWebhookResponse struct {
+		Webhook Webhook `json:"webhook"`
+	}
+
+	// A ReadWebhookResponse expresses ...
+	ReadWebhookResponse struct {
+		Webhooks []*Webhook `json:"webhooks"`
+	}
+
+	// A UpdateWebhookRequest expresses ... Active is kept when omitted.
+	UpdateWebhookRequest struct {
+		ID     int64           `json:"id"`
+		URL    string          `json:"url"`
+		Secret string          `json:"secret"`
+		Events []TODOEventType `json:"events"`
+		Active *bool           `json:"active"`
+	}
+	// A UpdateWebhookResponse expresses ...
+	UpdateWebhookResponse struct {
+		Webhook Webhook `json:"webhook"`
+	}
+
+	// A DeleteWebhookRequest expresses ...
+	DeleteWebhookRequest struct {
+		ID int64 `json:"id"`
+	}
+	// A DeleteWebhookResponse expresses ...
+	DeleteWebhookResponse struct {
+	}
+
+	// A ReadWebhookDeliveryResponse expresses ...
+	ReadWebhookDeliveryResponse struct {
+		Deliveries []*WebhookDelivery `json:"deliveries"`
+	}
+)
@@ -0,0 +1,52 @@
+// Package requestid carries the ID correlating the logs, audit records and
+// queries of one request.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// MaxLength bounds the IDs accepted from clients.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns a copy of parent that carries id.
+func NewContext(parent context.Context, id string) context.Context {
+	return context.WithValue(parent, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id may be used as given by a client. IDs are limited
+// to letters, digits and "-_.:" so that they are safe in headers, logs and
+// SQL comments.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
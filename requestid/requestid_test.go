@@ -0,0 +1,69 @@
+package requestid_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+)
+
+func TestValid(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		id   string
+		want bool
+	}{
+		"Generated":         {id: "0af7651916cd43dd8448eb211c80319c", want: true},
+		"UUID":              {id: "8f9c2e4a-3b1d-4c5e-9f7a-1b2c3d4e5f60", want: true},
+		"Punctuation":       {id: "trace_1.span:2", want: true},
+		"Longest":           {id: strings.Repeat("a", requestid.MaxLength), want: true},
+		"Too long":          {id: strings.Repeat("a", requestid.MaxLength+1)},
+		"Empty":             {id: ""},
+		"Space":             {id: "a b"},
+		"Comment end":       {id: "a*/b"},
+		"Newline":           {id: "a\nb"},
+		"Quote":             {id: `a"b`},
+		"Non-ASCII letters": {id: "ｒｅｑ"},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := requestid.Valid(tc.id); got != tc.want {
+				t.Errorf("Valid(%q) = %t, want %t", tc.id, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := requestid.New()
+		if len(id) != 32 || !requestid.Valid(id) {
+			t.Errorf("New() = %q, want 32 valid characters", id)
+		}
+		if seen[id] {
+			t.Errorf("New() repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	if id, ok := requestid.FromContext(context.Background()); ok {
+		t.Errorf("FromContext of an empty context = %q, want none", id)
+	}
+	if id, ok := requestid.FromContext(requestid.NewContext(context.Background(), "")); ok {
+		t.Errorf("FromContext of an empty ID = %q, want none", id)
+	}
+	if id, ok := requestid.FromContext(requestid.NewContext(context.Background(), "abc")); !ok || id != "abc" {
+		t.Errorf("FromContext = %q, %t, want abc", id, ok)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package restart
+
+import (
+	"os"
+	"syscall"
+)
+
+// setNonblock puts f back in non-blocking mode. Passing a file to a child
+// process puts it in blocking mode, which the listener sharing its socket
+// would block on, out of reach of Close.
+func setNonblock(f *os.File) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rc.Control(func(fd uintptr) {
+		setErr = syscall.SetNonblock(int(fd), true)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
@@ -0,0 +1,9 @@
+package restart
+
+import "os"
+
+// setNonblock does nothing as files cannot be passed to child processes on
+// windows.
+func setNonblock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package restart replaces the running process by a new one started from the
+// same executable, handing it the listening sockets so that no connection is
+// refused in between.
+package restart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/listener"
+)
+
+// readyFDEnv names the descriptor a restarted process writes to once ready.
+const readyFDEnv = "GO_STATIONS_READY_FD"
+
+// Start starts the executable of the process again with the same arguments,
+// handing it lns, and waits until it reports ready with Ready. The new
+// process is killed if it is not ready before ctx is done.
+func Start(ctx context.Context, lns []*listener.Listener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files, err := listener.Files(lns)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(environ(), listener.HandoffEnv(lns)...)
+	// ExtraFiles start at descriptor 3
+	cmd.Env = append(cmd.Env, readyFDEnv+"="+strconv.Itoa(3+len(files)))
+	err = cmd.Start()
+	// only the child writes; EOF tells it exited
+	readyW.Close()
+	for _, f := range files {
+		if nbErr := setNonblock(f); nbErr != nil && err == nil {
+			err = nbErr
+		}
+	}
+	if err != nil {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return err
+	}
+
+	readc := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := ready.Read(b[:])
+		readc <- err
+	}()
+	select {
+	case err = <-readc:
+		if err != nil {
+			err = fmt.Errorf("restart: process %d exited before being ready", cmd.Process.Pid)
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("restart: process %d not ready: %w", cmd.Process.Pid, ctx.Err())
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	for _, ln := range lns {
+		if ul, ok := ln.Listener.(*net.UnixListener); ok {
+			// the socket file now belongs to the new process
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	// the new process outlives this one, which no longer waits for it
+	return cmd.Process.Release()
+}
+
+// environ returns the environment without the variables of a previous
+// handoff.
+func environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case listener.HandoffFDsEnv, listener.HandoffAddrsEnv, readyFDEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// Ready tells the process that started this one with Start that it is ready
+// to serve. It does nothing when the process was not started by Start.
+func Ready() error {
+	s := os.Getenv(readyFDEnv)
+	os.Unsetenv(readyFDEnv)
+	if s == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("restart: invalid " + readyFDEnv)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
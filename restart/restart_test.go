@@ -0,0 +1,90 @@
+package restart_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/listener"
+	"github.com/TechBowl-japan/go-stations/restart"
+)
+
+// modeEnv tells the test binary started by restart.Start how to behave.
+const modeEnv = "GO_STATIONS_RESTART_TEST"
+
+func TestStart(t *testing.T) {
+	switch os.Getenv(modeEnv) {
+	case "serve":
+		serveChild(t)
+		return
+	case "fail":
+		os.Exit(1)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("listeners cannot be handed to child processes on windows")
+	}
+
+	lns, err := listener.Listen([]string{"127.0.0.1:0"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns[0].Close()
+	addr := lns[0].Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	os.Setenv(modeEnv, "fail")
+	if err := restart.Start(ctx, lns); err == nil {
+		t.Error("a process exiting before being ready replaced this one")
+	}
+
+	os.Setenv(modeEnv, "serve")
+	defer os.Unsetenv(modeEnv)
+	if err := restart.Start(ctx, lns); err != nil {
+		t.Fatal(err)
+	}
+	// from now on, only the new process accepts on the socket
+	lns[0].Close()
+
+	res, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "new process" {
+		t.Errorf("got %q, want new process", body)
+	}
+}
+
+// serveChild serves one request on the listener handed by TestStart.
+func serveChild(t *testing.T) {
+	spec := os.Getenv(listener.HandoffAddrsEnv)
+	lns, err := listener.Listen([]string{spec}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte("new process"))
+		close(done)
+	})}
+	go srv.Serve(lns[0])
+	if err := restart.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("no request was received")
+	}
+	srv.Shutdown(context.Background())
+}
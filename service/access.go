@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// todoScope returns a WHERE clause fragment and its arguments restricting
+// todos to the ones the user in ctx can read, or modify when writable is set.
+// TODOs outside any list are visible to their owner, and ownerless TODOs
+// created before lists existed stay visible to everyone but are only
+// modified by internal calls.
+// Internal calls are not restricted, and other contexts without a user fail
+// with ErrUnauthenticated.
+func todoScope(ctx context.Context, writable bool) (string, []interface{}, error) {
+	if auth.IsInternal(ctx) {
+		return `1 = 1`, nil, nil
+	}
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return "", nil, ErrUnauthenticated
+	}
+
+	const (
+		visible  = `((list_id IS NULL AND owner IN ('', ?)) OR list_id IN (SELECT list_id FROM list_members WHERE user_id = ?))`
+		editable = `((list_id IS NULL AND owner = ?) OR list_id IN (SELECT list_id FROM list_members WHERE user_id = ? AND permission IN ('editor', 'owner')))`
+	)
+	if writable {
+		return editable, []interface{}{user, user}, nil
+	}
+	return visible, []interface{}{user, user}, nil
+}
+
+// listPermission returns the permission the user in ctx has on the list.
+// Internal calls are granted owner permission on existing lists.
+func listPermission(ctx context.Context, q queryer, listID int64) (model.ListPermission, error) {
+	const (
+		exists = `SELECT 1 FROM lists WHERE id = ?`
+		member = `SELECT permission FROM list_members WHERE list_id = ? AND user_id = ?`
+	)
+
+	if auth.IsInternal(ctx) {
+		var one int
+		err := q.QueryRowContext(ctx, exists, listID).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", &model.ErrNotFound{RowIDs: []int64{listID}}
+		}
+		if err != nil {
+			return "", err
+		}
+		return model.PermissionOwner, nil
+	}
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+
+	var permission model.ListPermission
+	err := q.QueryRowContext(ctx, member, listID, user).Scan(&permission)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Lists the user is not a member of are indistinguishable from missing ones.
+		return "", &model.ErrNotFound{RowIDs: []int64{listID}}
+	}
+	if err != nil {
+		return "", err
+	}
+	return permission, nil
+}
+
+// requireListPermission fails unless the user in ctx has at least required on the list.
+func requireListPermission(ctx context.Context, q queryer, listID int64, required model.ListPermission) error {
+	permission, err := listPermission(ctx, q, listID)
+	if err != nil {
+		return err
+	}
+	if !permission.Allows(required) {
+		return &model.ErrForbidden{ListID: listID}
+	}
+	return nil
+}
+
+// sqliteTime formats t the way DATETIME('now') does so that stored values
+// compare consistently with the column defaults.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// An IdempotencyService stores Idempotency-Key records on DB.
+type IdempotencyService struct {
+	db *sql.DB
+}
+
+// NewIdempotencyService returns new IdempotencyService.
+func NewIdempotencyService(db *sql.DB) *IdempotencyService {
+	return &IdempotencyService{
+		db: db,
+	}
+}
+
+// Reserve claims key within scope for a request with the given hash. When the
+// key is already claimed, reserved is false and the existing record is returned.
+func (s *IdempotencyService) Reserve(ctx context.Context, scope, key, requestHash string, ttl time.Duration) (rec *model.IdempotencyRecord, reserved bool, err error) {
+	const (
+		purge  = `DELETE FROM idempotency_keys WHERE expires_at <= ?`
+		insert = `INSERT INTO idempotency_keys(scope, key, request_hash, expires_at) VALUES(?, ?, ?, ?)
+			ON CONFLICT(scope, key) DO NOTHING`
+	)
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, purge, sqliteTime(now)); err != nil {
+		return nil, false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, insert, scope, key, requestHash, sqliteTime(now.Add(ttl)))
+	if err != nil {
+		return nil, false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if rows == 1 {
+		return &model.IdempotencyRecord{Key: key, RequestHash: requestHash, ExpiresAt: now.Add(ttl)}, true, nil
+	}
+
+	rec, err = s.Read(ctx, scope, key)
+	return rec, false, err
+}
+
+// Read reads the record of key within scope.
+func (s *IdempotencyService) Read(ctx context.Context, scope, key string) (*model.IdempotencyRecord, error) {
+	const read = `SELECT request_hash, status, header, body, expires_at FROM idempotency_keys WHERE scope = ? AND key = ?`
+
+	var (
+		rec    = &model.IdempotencyRecord{Key: key}
+		status sql.NullInt64
+		header string
+	)
+	err := s.db.QueryRowContext(ctx, read, scope, key).Scan(&rec.RequestHash, &status, &header, &rec.Body, &rec.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.ErrNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.StatusCode = int(status.Int64)
+	if err := json.Unmarshal([]byte(header), &rec.Header); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Complete records the response of the request that reserved key.
+func (s *IdempotencyService) Complete(ctx context.Context, scope string, rec *model.IdempotencyRecord) error {
+	const update = `UPDATE idempotency_keys SET status = ?, header = ?, body = ? WHERE scope = ? AND key = ?`
+
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, update, rec.StatusCode, string(header), rec.Body, scope, rec.Key)
+	return err
+}
+
+// Release forgets key so that the request can be retried.
+func (s *IdempotencyService) Release(ctx context.Context, scope, key string) error {
+	const remove = `DELETE FROM idempotency_keys WHERE scope = ? AND key = ?`
+
+	_, err := s.db.ExecContext(ctx, remove, scope, key)
+	return err
+}
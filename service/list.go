@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// DefaultInviteTTL is used when an invite is created without an expiry.
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	// MaxInviteTTL bounds how long an invite link stays usable.
+	MaxInviteTTL = 30 * 24 * time.Hour
+)
+
+// ErrUnauthenticated is returned by operations that need a user in the context.
+var ErrUnauthenticated = errors.New("service: operation requires an authenticated user")
+
+// A ListService implements sharing TODO lists between users.
+type ListService struct {
+	db *sql.DB
+
+	onAccessChange []func()
+}
+
+// NewListService returns new ListService.
+func NewListService(db *sql.DB) *ListService {
+	return &ListService{
+		db: db,
+	}
+}
+
+// CreateList creates a list owned by the user on DB.
+func (s *ListService) CreateList(ctx context.Context, name string) (*model.List, error) {
+	const (
+		insert = `INSERT INTO lists(name) VALUES(?)`
+		member = `INSERT INTO list_members(list_id, user_id, permission) VALUES(?, ?, ?)`
+	)
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, insert, name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, member, id, user, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.readList(ctx, id)
+}
+
+// ReadLists reads the lists the user is a member of on DB.
+func (s *ListService) ReadLists(ctx context.Context) ([]*model.List, error) {
+	const read = `SELECT l.id, l.name, m.permission, l.created_at, l.updated_at FROM lists l
+		JOIN list_members m ON m.list_id = l.id WHERE m.user_id = ? ORDER BY l.id`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*model.List{}
+	for rows.Next() {
+		list := &model.List{}
+		if err := rows.Scan(&list.ID, &list.Name, &list.Permission, &list.CreatedAt, &list.UpdatedAt); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, rows.Err()
+}
+
+// OnAccessChange registers f to be called after every change of who can
+// read which TODOs, such as removing a member, for the caches of TODOs to
+// forget what the users could read before.
+func (s *ListService) OnAccessChange(f func()) {
+	s.onAccessChange = append(s.onAccessChange, f)
+}
+
+// accessChanged calls the functions registered with OnAccessChange.
+func (s *ListService) accessChanged() {
+	for _, f := range s.onAccessChange {
+		f()
+	}
+}
+
+// UpdateList renames the list on DB.
+func (s *ListService) UpdateList(ctx context.Context, id int64, name string) (*model.List, error) {
+	const update = `UPDATE lists SET name = ? WHERE id = ?`
+
+	if err := requireListPermission(ctx, s.db, id, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	if _, err := s.db.ExecContext(ctx, update, name, id); err != nil {
+		return nil, err
+	}
+
+	return s.readList(ctx, id)
+}
+
+// DeleteList deletes the list together with its TODOs, members and invites on DB.
+func (s *ListService) DeleteList(ctx context.Context, id int64) error {
+	deletes := []string{
+		`DELETE FROM todos WHERE list_id = ?`,
+		`DELETE FROM list_invites WHERE list_id = ?`,
+		`DELETE FROM list_members WHERE list_id = ?`,
+		`DELETE FROM lists WHERE id = ?`,
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireListPermission(ctx, tx, id, model.PermissionOwner); err != nil {
+		return err
+	}
+	for _, d := range deletes {
+		if _, err := tx.ExecContext(ctx, d, id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.accessChanged()
+
+	return nil
+}
+
+// ReadMembers reads the members of the list on DB.
+func (s *ListService) ReadMembers(ctx context.Context, listID int64) ([]*model.ListMember, error) {
+	const read = `SELECT list_id, user_id, permission, created_at FROM list_members WHERE list_id = ? ORDER BY created_at, user_id`
+
+	if err := requireListPermission(ctx, s.db, listID, model.PermissionViewer); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*model.ListMember{}
+	for rows.Next() {
+		member := &model.ListMember{}
+		if err := rows.Scan(&member.ListID, &member.UserID, &member.Permission, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// PutMember adds the user to the list or changes their permission on DB.
+func (s *ListService) PutMember(ctx context.Context, listID int64, userID string, permission model.ListPermission) (*model.ListMember, error) {
+	const upsert = `INSERT INTO list_members(list_id, user_id, permission) VALUES(?, ?, ?)
+		ON CONFLICT(list_id, user_id) DO UPDATE SET permission = excluded.permission`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := requireListPermission(ctx, tx, listID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	if permission != model.PermissionOwner {
+		if err := s.keepLastOwner(ctx, tx, listID, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, upsert, listID, userID, permission); err != nil {
+		return nil, err
+	}
+	member, err := s.readMember(ctx, tx, listID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.accessChanged()
+
+	return member, nil
+}
+
+// DeleteMember removes the user from the list on DB. Owners may remove
+// anyone and every member may remove themselves.
+func (s *ListService) DeleteMember(ctx context.Context, listID int64, userID string) error {
+	const remove = `DELETE FROM list_members WHERE list_id = ? AND user_id = ?`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	required := model.PermissionOwner
+	if user, _ := auth.UserFromContext(ctx); user == userID {
+		required = model.PermissionViewer
+	}
+	if err := requireListPermission(ctx, tx, listID, required); err != nil {
+		return err
+	}
+	if err := s.keepLastOwner(ctx, tx, listID, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, remove, listID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return &model.ErrNotFound{RowIDs: []int64{listID}}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.accessChanged()
+
+	return nil
+}
+
+// CreateInvite creates an invite token granting permission on the list until ttl elapses.
+func (s *ListService) CreateInvite(ctx context.Context, listID int64, permission model.ListPermission, ttl time.Duration) (*model.ListInvite, error) {
+	const insert = `INSERT INTO list_invites(token, list_id, permission, created_by, expires_at) VALUES(?, ?, ?, ?, ?)`
+
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+	if ttl > MaxInviteTTL {
+		ttl = MaxInviteTTL
+	}
+	if err := requireListPermission(ctx, s.db, listID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	user, _ := auth.UserFromContext(ctx)
+	if _, err := s.db.ExecContext(ctx, insert, token, listID, permission, user, sqliteTime(time.Now().Add(ttl))); err != nil {
+		return nil, err
+	}
+
+	return s.readInvite(ctx, token)
+}
+
+// ReadInvites reads the invites of the list that have not expired yet on DB.
+func (s *ListService) ReadInvites(ctx context.Context, listID int64) ([]*model.ListInvite, error) {
+	const read = `SELECT token, list_id, permission, created_by, expires_at, created_at FROM list_invites
+		WHERE list_id = ? AND expires_at > ? ORDER BY created_at`
+
+	if err := requireListPermission(ctx, s.db, listID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, listID, sqliteTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*model.ListInvite{}
+	for rows.Next() {
+		invite := &model.ListInvite{}
+		if err := rows.Scan(&invite.Token, &invite.ListID, &invite.Permission, &invite.CreatedBy, &invite.ExpiresAt, &invite.CreatedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// DeleteInvite revokes the invite on DB.
+func (s *ListService) DeleteInvite(ctx context.Context, token string) error {
+	const remove = `DELETE FROM list_invites WHERE token = ?`
+
+	invite, err := s.readInvite(ctx, token)
+	if err != nil {
+		return err
+	}
+	if err := requireListPermission(ctx, s.db, invite.ListID, model.PermissionOwner); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, remove, token)
+	return err
+}
+
+// AcceptInvite makes the user a member of the invite's list. Existing
+// members keep their current permission.
+func (s *ListService) AcceptInvite(ctx context.Context, token string) (*model.ListMember, error) {
+	const insert = `INSERT INTO list_members(list_id, user_id, permission) VALUES(?, ?, ?)
+		ON CONFLICT(list_id, user_id) DO NOTHING`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	invite, err := s.readInvite(ctx, token)
+	var notFound *model.ErrNotFound
+	if errors.As(err, &notFound) || (err == nil && time.Now().After(invite.ExpiresAt)) {
+		return nil, &model.ErrInviteExpired{Token: token}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, insert, invite.ListID, user, invite.Permission); err != nil {
+		return nil, err
+	}
+	member, err := s.readMember(ctx, tx, invite.ListID, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.accessChanged()
+
+	return member, nil
+}
+
+func (s *ListService) readList(ctx context.Context, id int64) (*model.List, error) {
+	const read = `SELECT id, name, created_at, updated_at FROM lists WHERE id = ?`
+
+	permission, err := listPermission(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	list := &model.List{Permission: permission}
+	if err := s.db.QueryRowContext(ctx, read, id).Scan(&list.ID, &list.Name, &list.CreatedAt, &list.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *ListService) readMember(ctx context.Context, q queryer, listID int64, userID string) (*model.ListMember, error) {
+	const read = `SELECT list_id, user_id, permission, created_at FROM list_members WHERE list_id = ? AND user_id = ?`
+
+	member := &model.ListMember{}
+	err := q.QueryRowContext(ctx, read, listID, userID).Scan(&member.ListID, &member.UserID, &member.Permission, &member.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.ErrNotFound{RowIDs: []int64{listID}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *ListService) readInvite(ctx context.Context, token string) (*model.ListInvite, error) {
+	const read = `SELECT token, list_id, permission, created_by, expires_at, created_at FROM list_invites WHERE token = ?`
+
+	invite := &model.ListInvite{}
+	err := s.db.QueryRowContext(ctx, read, token).Scan(&invite.Token, &invite.ListID, &invite.Permission, &invite.CreatedBy, &invite.ExpiresAt, &invite.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.ErrNotFound{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// keepLastOwner refuses changes that would leave the list without an owner.
+func (s *ListService) keepLastOwner(ctx context.Context, q queryer, listID int64, userID string) error {
+	const owners = `SELECT COUNT(*), SUM(user_id = ?) FROM list_members WHERE list_id = ? AND permission = 'owner'`
+
+	var (
+		count   int64
+		isOwner sql.NullInt64
+	)
+	if err := q.QueryRowContext(ctx, owners, userID, listID).Scan(&count, &isOwner); err != nil {
+		return err
+	}
+	if count == 1 && isOwner.Int64 == 1 {
+		return &model.ErrForbidden{ListID: listID}
+	}
+	return nil
+}
+
+// newToken returns a random hex string, unguessable enough for invite tokens
+// and webhook secrets.
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
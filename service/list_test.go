@@ -0,0 +1,342 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// errKind tells the model error err is, for comparing outcomes in tables.
+func errKind(err error) string {
+	var (
+		notFound  *model.ErrNotFound
+		forbidden *model.ErrForbidden
+		expired   *model.ErrInviteExpired
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &notFound):
+		return "not found"
+	case errors.As(err, &forbidden):
+		return "forbidden"
+	case errors.As(err, &expired):
+		return "expired"
+	case errors.Is(err, service.ErrUnauthenticated):
+		return "unauthenticated"
+	default:
+		return err.Error()
+	}
+}
+
+func TestListServicePermissions(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "list.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	lists := service.NewListService(d)
+	todos := service.NewTODOService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	list, err := lists.CreateList(alice, "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.PutMember(alice, list.ID, "bob", model.PermissionViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.PutMember(alice, list.ID, "carol", model.PermissionEditor); err != nil {
+		t.Fatal(err)
+	}
+	todo, err := todos.CreateListTODO(alice, list.ID, "milk", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := map[string]func(ctx context.Context) error{
+		"read todos": func(ctx context.Context) error {
+			_, err := todos.ReadListTODO(ctx, list.ID, 0, 10)
+			return err
+		},
+		"read members": func(ctx context.Context) error {
+			_, err := lists.ReadMembers(ctx, list.ID)
+			return err
+		},
+		"create todo": func(ctx context.Context) error {
+			_, err := todos.CreateListTODO(ctx, list.ID, "eggs", "")
+			return err
+		},
+		"update todo": func(ctx context.Context) error {
+			_, err := todos.UpdateTODO(ctx, todo.ID, "oat milk", "")
+			return err
+		},
+		"rename list": func(ctx context.Context) error {
+			_, err := lists.UpdateList(ctx, list.ID, "shopping")
+			return err
+		},
+		"create invite": func(ctx context.Context) error {
+			_, err := lists.CreateInvite(ctx, list.ID, model.PermissionViewer, 0)
+			return err
+		},
+	}
+	cases := map[string]struct {
+		user string
+		want map[string]string
+	}{
+		"Owner": {
+			user: "alice",
+			want: map[string]string{},
+		},
+		"Editor": {
+			user: "carol",
+			want: map[string]string{"rename list": "forbidden", "create invite": "forbidden"},
+		},
+		"Viewer": {
+			user: "bob",
+			want: map[string]string{"create todo": "forbidden", "update todo": "forbidden", "rename list": "forbidden", "create invite": "forbidden"},
+		},
+		"Not a member": {
+			user: "dave",
+			want: map[string]string{"read todos": "not found", "read members": "not found", "create todo": "not found", "update todo": "not found", "rename list": "not found", "create invite": "not found"},
+		},
+	}
+	for name, tc := range cases {
+		ctx := auth.ContextWithUser(context.Background(), tc.user)
+		for op, do := range ops {
+			if got := errKind(do(ctx)); got != tc.want[op] {
+				t.Errorf("%s: %s = %q, want %q", name, op, got, tc.want[op])
+			}
+		}
+	}
+}
+
+func TestListServiceLastOwner(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "list.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	lists := service.NewListService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+	list, err := lists.CreateList(alice, "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.PutMember(alice, list.ID, "bob", model.PermissionViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{"demote the last owner", func() error {
+			_, err := lists.PutMember(alice, list.ID, "alice", model.PermissionEditor)
+			return err
+		}, "forbidden"},
+		{"last owner leaves", func() error { return lists.DeleteMember(alice, list.ID, "alice") }, "forbidden"},
+		{"viewer removes the owner", func() error { return lists.DeleteMember(bob, list.ID, "alice") }, "forbidden"},
+		{"viewer leaves", func() error { return lists.DeleteMember(bob, list.ID, "bob") }, ""},
+		{"promote another owner", func() error {
+			_, err := lists.PutMember(alice, list.ID, "bob", model.PermissionOwner)
+			return err
+		}, ""},
+		{"demote one of two owners", func() error {
+			_, err := lists.PutMember(bob, list.ID, "alice", model.PermissionViewer)
+			return err
+		}, ""},
+		{"owner leaves another owner", func() error { return lists.DeleteMember(bob, list.ID, "bob") }, "forbidden"},
+		{"remove a non-member", func() error { return lists.DeleteMember(bob, list.ID, "carol") }, "not found"},
+	}
+	for _, s := range steps {
+		if got := errKind(s.do()); got != s.want {
+			t.Errorf("%s: error = %q, want %q", s.name, got, s.want)
+		}
+	}
+}
+
+func TestListServiceInvites(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "list.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	lists := service.NewListService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+	carol := auth.ContextWithUser(context.Background(), "carol")
+	list, err := lists.CreateList(alice, "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, err := lists.CreateInvite(alice, list.ID, model.PermissionEditor, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := lists.AcceptInvite(bob, invite.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.UserID != "bob" || member.Permission != model.PermissionEditor {
+		t.Errorf("unexpected member, given = %+v", member)
+	}
+	// accepting again keeps the permission the member has
+	if _, err := lists.PutMember(alice, list.ID, "bob", model.PermissionViewer); err != nil {
+		t.Fatal(err)
+	}
+	if member, err = lists.AcceptInvite(bob, invite.Token); err != nil || member.Permission != model.PermissionViewer {
+		t.Errorf("unexpected accept by a member, given = %+v, %v", member, err)
+	}
+	if _, err := lists.AcceptInvite(context.Background(), invite.Token); errKind(err) != "unauthenticated" {
+		t.Errorf("unexpected accept without a user, given = %v", err)
+	}
+	if _, err := lists.AcceptInvite(carol, "unknown"); errKind(err) != "expired" {
+		t.Errorf("unexpected accept of an unknown token, given = %v", err)
+	}
+
+	expired, err := lists.CreateInvite(alice, list.ID, model.PermissionViewer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute).UTC().Format("2006-01-02 15:04:05")
+	if _, err := d.Exec(`UPDATE list_invites SET expires_at = ? WHERE token = ?`, past, expired.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.AcceptInvite(carol, expired.Token); errKind(err) != "expired" {
+		t.Errorf("unexpected accept of an expired invite, given = %v", err)
+	}
+	invites, err := lists.ReadInvites(alice, list.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 1 || invites[0].Token != invite.Token {
+		t.Errorf("unexpected invites, given = %+v", invites)
+	}
+
+	if err := lists.DeleteInvite(bob, invite.Token); errKind(err) != "forbidden" {
+		t.Errorf("unexpected revoke by a viewer, given = %v", err)
+	}
+	if err := lists.DeleteInvite(alice, invite.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.AcceptInvite(carol, invite.Token); errKind(err) != "expired" {
+		t.Errorf("unexpected accept of a revoked invite, given = %v", err)
+	}
+}
+
+func TestTODOServiceOwnerless(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	todos := service.NewTODOService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	internal := auth.ContextInternal(context.Background())
+	// created before users existed
+	legacy, err := todos.CreateTODO(internal, "legacy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := todos.GetTODO(alice, legacy.ID); err != nil {
+		t.Errorf("unexpected read by a user, given = %v", err)
+	}
+	if _, err := todos.UpdateTODO(alice, legacy.ID, "mine", ""); errKind(err) != "forbidden" {
+		t.Errorf("unexpected update by a user, given = %v", err)
+	}
+	if err := todos.DeleteTODO(alice, []int64{legacy.ID}); errKind(err) != "forbidden" {
+		t.Errorf("unexpected delete by a user, given = %v", err)
+	}
+	if _, err := todos.UpdateTODO(internal, legacy.ID, "updated", ""); err != nil {
+		t.Errorf("unexpected internal update, given = %v", err)
+	}
+	// calls are internal only when marked so
+	if _, err := todos.UpdateTODO(context.Background(), legacy.ID, "unmarked", ""); errKind(err) != "unauthenticated" {
+		t.Errorf("unexpected update without a user, given = %v", err)
+	}
+	if _, err := todos.GetTODO(auth.ContextWithUser(internal, ""), legacy.ID); errKind(err) != "unauthenticated" {
+		t.Errorf("unexpected read by an empty user, given = %v", err)
+	}
+}
+
+func TestTODOServiceDeleteAllOrNothing(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	lists := service.NewListService(d)
+	todos := service.NewTODOService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+	carol := auth.ContextWithUser(context.Background(), "carol")
+	list, err := lists.CreateList(alice, "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.PutMember(alice, list.ID, "bob", model.PermissionViewer); err != nil {
+		t.Fatal(err)
+	}
+	create := func(ctx context.Context, listID int64) int64 {
+		t.Helper()
+		todo, err := todos.CreateListTODO(ctx, listID, "todo", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return todo.ID
+	}
+	own := create(bob, 0)
+	readOnly := create(alice, list.ID)
+	invisible := create(carol, 0)
+
+	cases := []struct {
+		name string
+		ids  []int64
+		want string
+	}{
+		{"with a read-only TODO", []int64{own, readOnly}, "forbidden"},
+		{"with an invisible TODO", []int64{own, invisible}, "not found"},
+		{"with a missing TODO", []int64{own, invisible + 1}, "not found"},
+	}
+	for _, tc := range cases {
+		if err := todos.DeleteTODO(bob, tc.ids); errKind(err) != tc.want {
+			t.Errorf("delete %s: error = %v, want %s", tc.name, err, tc.want)
+		}
+		if _, err := todos.GetTODO(bob, own); err != nil {
+			t.Errorf("delete %s: the deletable TODO is gone, given = %v", tc.name, err)
+		}
+	}
+	// the same TODO twice is deleted once
+	if err := todos.DeleteTODO(bob, []int64{own, own}); err != nil {
+		t.Errorf("unexpected delete of a repeated id, given = %v", err)
+	}
+	if _, err := todos.GetTODO(bob, own); errKind(err) != "not found" {
+		t.Errorf("unexpected read of a deleted TODO, given = %v", err)
+	}
+}
@@ -3,12 +3,31 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/logger"
 	"github.com/TechBowl-japan/go-stations/model"
 )
 
+// A TODOStore reads and writes TODOs. TODOService implements it on DB, and
+// CachedTODOService around another TODOStore.
+type TODOStore interface {
+	CreateTODO(ctx context.Context, subject, description string) (*model.TODO, error)
+	CreateListTODO(ctx context.Context, listID int64, subject, description string) (*model.TODO, error)
+	ReadTODO(ctx context.Context, prevID, size int64) ([]*model.TODO, error)
+	ReadListTODO(ctx context.Context, listID, prevID, size int64) ([]*model.TODO, error)
+	ReadListTODOVersions(ctx context.Context, listID, prevID, size int64) ([]*model.TODOVersion, error)
+	GetTODO(ctx context.Context, id int64) (*model.TODO, error)
+	GetTODOVersion(ctx context.Context, id int64) (*model.TODOVersion, error)
+	LastTODODeletion(ctx context.Context) (time.Time, error)
+	UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error)
+	DeleteTODO(ctx context.Context, ids []int64) error
+}
+
 // A TODOService implements CRUD of TODO entities.
 type TODOService struct {
 	db *sql.DB
@@ -21,20 +40,58 @@ func NewTODOService(db *sql.DB) *TODOService {
 	}
 }
 
+const todoColumns = `id, subject, description, owner, list_id, created_at, updated_at`
+
+// scanTODO scans a row selected with todoColumns.
+func scanTODO(row interface{ Scan(...interface{}) error }) (*model.TODO, error) {
+	var (
+		todo   = &model.TODO{}
+		listID sql.NullInt64
+	)
+	err := row.Scan(&todo.ID, &todo.Subject, &todo.Description, &todo.Owner, &listID, &todo.CreatedAt, &todo.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	todo.ListID = listID.Int64
+	return todo, nil
+}
+
 // CreateTODO creates a TODO on DB.
 func (s *TODOService) CreateTODO(ctx context.Context, subject, description string) (*model.TODO, error) {
+	return s.CreateListTODO(ctx, 0, subject, description)
+}
+
+// CreateListTODO creates a TODO in the list on DB. A zero listID creates a
+// TODO owned by the user outside of any list.
+func (s *TODOService) CreateListTODO(ctx context.Context, listID int64, subject, description string) (_ *model.TODO, err error) {
+	ctx, span := startSpan(ctx, "TODOService.CreateListTODO")
+	span.SetAttribute("list_id", listID)
+	defer func() { span.End(err) }()
+
 	const (
-		insert  = `INSERT INTO todos(subject, description) VALUES(?, ?)`
-		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
+		insert  = `INSERT INTO todos(subject, description, owner, list_id) VALUES(?, ?, ?, ?)`
+		confirm = `SELECT ` + todoColumns + ` FROM todos WHERE id = ?`
 	)
 
+	var list interface{}
+	if listID != 0 {
+		if err := requireListPermission(ctx, s.db, listID, model.PermissionEditor); err != nil {
+			return nil, err
+		}
+		list = listID
+	}
+	owner, ok := auth.UserFromContext(ctx)
+	if !ok && !auth.IsInternal(ctx) {
+		return nil, ErrUnauthenticated
+	}
+
 	stmt, err := s.db.PrepareContext(ctx, insert)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(subject, description)
+	result, err := stmt.ExecContext(ctx, subject, description, owner, list)
 	if err != nil {
 		return nil, err
 	}
@@ -43,118 +100,346 @@ func (s *TODOService) CreateTODO(ctx context.Context, subject, description strin
 	if err != nil {
 		return nil, err
 	}
+	logger.FromContext(ctx).Debug("todo created", "id", lastId, "list_id", listID)
 
-	todo := &model.TODO{ID: lastId}
-	err = s.db.QueryRowContext(ctx, confirm, lastId).Scan(&todo.Subject, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-
-	return todo, nil
+	return scanTODO(s.db.QueryRowContext(ctx, confirm, lastId))
 }
 
 // ReadTODO reads TODOs on DB.
 func (s *TODOService) ReadTODO(ctx context.Context, prevID, size int64) ([]*model.TODO, error) {
-	const (
-		read       = `SELECT id, subject, description, created_at, updated_at FROM todos ORDER BY id DESC LIMIT ?`
-		readWithID = `SELECT id, subject, description, created_at, updated_at FROM todos WHERE id < ? ORDER BY id DESC LIMIT ?`
-	)
+	return s.ReadListTODO(ctx, 0, prevID, size)
+}
 
-	var (
-		rows *sql.Rows
-		err  error
-	)
-	if prevID == 0 {
-		rows, err = s.db.QueryContext(ctx, read, size)
-	} else {
-		rows, err = s.db.QueryContext(ctx, readWithID, prevID, size)
+// ReadListTODO reads TODOs of the list on DB. A zero listID reads TODOs from
+// every list the user can access.
+func (s *TODOService) ReadListTODO(ctx context.Context, listID, prevID, size int64) (_ []*model.TODO, err error) {
+	ctx, span := startSpan(ctx, "TODOService.ReadListTODO")
+	span.SetAttribute("list_id", listID)
+	span.SetAttribute("prev_id", prevID)
+	span.SetAttribute("size", size)
+	defer func() { span.End(err) }()
+
+	query, args, err := s.listQuery(ctx, todoColumns, listID, prevID, size)
+	if err != nil {
+		return nil, err
 	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	todos := []*model.TODO{}
 	for rows.Next() {
-		todo := &model.TODO{}
-		err = rows.Scan(&todo.ID, &todo.Subject, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
+		todo, err := scanTODO(rows)
 		if err != nil {
 			return nil, err
 		}
 		todos = append(todos, todo)
 	}
+	logger.FromContext(ctx).Debug("todos read", "list_id", listID, "prev_id", prevID, "count", len(todos))
 
-	return todos, nil
+	return todos, rows.Err()
 }
 
-// UpdateTODO updates the TODO on DB.
-func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
-	const (
-		update  = `UPDATE todos SET subject = ?, description = ? WHERE id = ?`
-		confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
-	)
+// listQuery returns the query selecting columns of the TODOs ReadListTODO
+// reads, after checking the user may read the list.
+func (s *TODOService) listQuery(ctx context.Context, columns string, listID, prevID, size int64) (string, []interface{}, error) {
+	const readFmt = `SELECT %s FROM todos WHERE %s ORDER BY id DESC LIMIT ?`
 
-	stmt, err := s.db.PrepareContext(ctx, update)
+	cond, args, err := todoScope(ctx, false)
+	if err != nil {
+		return "", nil, err
+	}
+	if listID != 0 {
+		if err := requireListPermission(ctx, s.db, listID, model.PermissionViewer); err != nil {
+			return "", nil, err
+		}
+		cond, args = `list_id = ?`, []interface{}{listID}
+	}
+	if prevID != 0 {
+		cond += ` AND id < ?`
+		args = append(args, prevID)
+	}
+	args = append(args, size)
+
+	return fmt.Sprintf(readFmt, columns, cond), args, nil
+}
+
+// GetTODO reads the TODO on DB by id.
+func (s *TODOService) GetTODO(ctx context.Context, id int64) (_ *model.TODO, err error) {
+	ctx, span := startSpan(ctx, "TODOService.GetTODO")
+	span.SetAttribute("id", id)
+	defer func() { span.End(err) }()
+
+	const readFmt = `SELECT ` + todoColumns + ` FROM todos WHERE id = ? AND %s`
+
+	cond, scope, err := todoScope(ctx, false)
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
+	todo, err := scanTODO(s.db.QueryRowContext(ctx, fmt.Sprintf(readFmt, cond), append([]interface{}{id}, scope...)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.ErrNotFound{RowIDs: []int64{id}}
+	}
+	return todo, err
+}
+
+const todoVersionColumns = `id, version, updated_at`
+
+// scanTODOVersion scans a row selected with todoVersionColumns.
+func scanTODOVersion(row interface{ Scan(...interface{}) error }) (*model.TODOVersion, error) {
+	v := &model.TODOVersion{}
+	if err := row.Scan(&v.ID, &v.Version, &v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
 
-	result, err := stmt.Exec(subject, description, id)
+// ReadListTODOVersions reads the versions of the TODOs ReadListTODO reads
+// with the same arguments, which is cheaper than reading the TODOs.
+func (s *TODOService) ReadListTODOVersions(ctx context.Context, listID, prevID, size int64) (_ []*model.TODOVersion, err error) {
+	ctx, span := startSpan(ctx, "TODOService.ReadListTODOVersions")
+	span.SetAttribute("list_id", listID)
+	defer func() { span.End(err) }()
+
+	query, args, err := s.listQuery(ctx, todoVersionColumns, listID, prevID, size)
 	if err != nil {
 		return nil, err
 	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	rows, err := result.RowsAffected()
+	versions := []*model.TODOVersion{}
+	for rows.Next() {
+		v, err := scanTODOVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetTODOVersion reads the version of the TODO on DB by id.
+func (s *TODOService) GetTODOVersion(ctx context.Context, id int64) (_ *model.TODOVersion, err error) {
+	ctx, span := startSpan(ctx, "TODOService.GetTODOVersion")
+	span.SetAttribute("id", id)
+	defer func() { span.End(err) }()
+
+	const readFmt = `SELECT ` + todoVersionColumns + ` FROM todos WHERE id = ? AND %s`
+
+	cond, scope, err := todoScope(ctx, false)
 	if err != nil {
 		return nil, err
 	}
-	if rows == 0 {
+	v, err := scanTODOVersion(s.db.QueryRowContext(ctx, fmt.Sprintf(readFmt, cond), append([]interface{}{id}, scope...)...))
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, &model.ErrNotFound{RowIDs: []int64{id}}
 	}
+	return v, err
+}
 
-	lastId, err := result.LastInsertId()
+// LastTODODeletion returns when a TODO was last deleted from DB, by anyone,
+// or the zero time when none ever was. Deletions leave no row behind to
+// version, so lists tell them by this time.
+func (s *TODOService) LastTODODeletion(ctx context.Context) (_ time.Time, err error) {
+	ctx, span := startSpan(ctx, "TODOService.LastTODODeletion")
+	defer func() { span.End(err) }()
+
+	const read = `SELECT deleted_at FROM todo_deletions WHERE id = 1`
+
+	var deletedAt time.Time
+	err = s.db.QueryRowContext(ctx, read).Scan(&deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return deletedAt, err
+}
+
+// UpdateTODO updates the TODO on DB.
+func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (_ *model.TODO, err error) {
+	ctx, span := startSpan(ctx, "TODOService.UpdateTODO")
+	span.SetAttribute("id", id)
+	defer func() { span.End(err) }()
+
+	const (
+		updateFmt = `UPDATE todos SET subject = ?, description = ? WHERE id = ? AND %s`
+		confirm   = `SELECT ` + todoColumns + ` FROM todos WHERE id = ?`
+	)
+
+	cond, scope, err := todoScope(ctx, true)
 	if err != nil {
 		return nil, err
 	}
+	stmt, err := s.db.PrepareContext(ctx, fmt.Sprintf(updateFmt, cond))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
 
-	todo := &model.TODO{ID: lastId}
-	err = s.db.QueryRowContext(ctx, confirm, lastId).Scan(&todo.Subject, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt)
+	result, err := stmt.ExecContext(ctx, append([]interface{}{subject, description, id}, scope...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	return todo, nil
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, s.explainMissing(ctx, id)
+	}
+	logger.FromContext(ctx).Debug("todo updated", "id", id)
+
+	return scanTODO(s.db.QueryRowContext(ctx, confirm, id))
 }
 
-// DeleteTODO deletes TODOs on DB by ids.
-func (s *TODOService) DeleteTODO(ctx context.Context, ids []int64) error {
+// DeleteTODO deletes TODOs on DB by ids. Either every TODO is deleted or
+// none is: TODOs the user cannot see fail it with ErrNotFound, and those they
+// may only read with ErrForbidden.
+func (s *TODOService) DeleteTODO(ctx context.Context, ids []int64) (err error) {
 	if len(ids) == 0 {
 		return nil
 	}
+	ctx, span := startSpan(ctx, "TODOService.DeleteTODO")
+	span.SetAttribute("count", len(ids))
+	defer func() { span.End(err) }()
 
-	const deleteFmt = `DELETE FROM todos WHERE id IN (?%s)`
-	stmt, err := s.db.PrepareContext(ctx, fmt.Sprintf(deleteFmt, strings.Repeat(", ?", len(ids)-1)))
+	const (
+		readFmt   = `SELECT id FROM todos WHERE id IN (?%s) AND %s`
+		deleteFmt = `DELETE FROM todos WHERE id IN (?%s) AND %s`
+		remaining = `SELECT list_id FROM todos WHERE id IN (?%s) LIMIT 1`
+	)
+	visible, readScope, err := todoScope(ctx, false)
+	if err != nil {
+		return err
+	}
+	editable, writeScope, err := todoScope(ctx, true)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	var ids_any []interface{}
+	var (
+		unique []int64
+		args   []interface{}
+		seen   = map[int64]bool{}
+	)
 	for _, id := range ids {
-		ids_any = append(ids_any, id)
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+			args = append(args, id)
+		}
 	}
-	result, err := stmt.Exec(ids_any...)
+	more := strings.Repeat(", ?", len(unique)-1)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rows, err := result.RowsAffected()
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(readFmt, more, visible), append(args, readScope...)...)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return &model.ErrNotFound{RowIDs: ids}
+	found := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		found[id] = true
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(found) != len(unique) {
+		var missing []int64
+		for _, id := range unique {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		return &model.ErrNotFound{RowIDs: missing}
+	}
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(deleteFmt, more, editable), append(args, writeScope...)...)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted != int64(len(unique)) {
+		// the TODOs left are the ones the user may only read
+		var listID sql.NullInt64
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf(remaining, more), args...).Scan(&listID); err != nil {
+			return err
+		}
+		return &model.ErrForbidden{ListID: listID.Int64}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.FromContext(ctx).Debug("todos deleted", "ids", ids, "rows", deleted)
 
 	return nil
 }
+
+// explainMissing tells apart a TODO the user may only read from one that is
+// invisible to them after a write matched no rows.
+func (s *TODOService) explainMissing(ctx context.Context, id int64) error {
+	const readFmt = `SELECT list_id FROM todos WHERE id = ? AND %s`
+
+	cond, scope, err := todoScope(ctx, false)
+	if err != nil {
+		return err
+	}
+	var listID sql.NullInt64
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(readFmt, cond), append([]interface{}{id}, scope...)...).Scan(&listID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.ErrNotFound{RowIDs: []int64{id}}
+	}
+	if err != nil {
+		return err
+	}
+	return &model.ErrForbidden{ListID: listID.Int64}
+}
+
+// CountTODO counts every TODO on DB regardless of the user. TODOs are closed
+// by deleting them, so these are the open ones.
+func (s *TODOService) CountTODO(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "TODOService.CountTODO")
+	defer func() { span.End(err) }()
+
+	const count = `SELECT COUNT(*) FROM todos`
+
+	var n int64
+	if err := s.db.QueryRowContext(ctx, count).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CountCreatedTODO counts every TODO ever created on DB, deleted ones too.
+func (s *TODOService) CountCreatedTODO(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "TODOService.CountCreatedTODO")
+	defer func() { span.End(err) }()
+
+	// AUTOINCREMENT never reuses IDs, so the last one handed out is the count
+	const count = `SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0)`
+
+	var n int64
+	if err := s.db.QueryRowContext(ctx, count).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/cache"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/model"
+	"golang.org/x/sync/singleflight"
+)
+
+// A TODOCacheConfig bounds the reads a CachedTODOService keeps. Size is the
+// number of TODOs held across all reads, and TTL how long a read is kept.
+type TODOCacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+// A CachedTODOService keeps the reads of another TODOStore per user and
+// arguments, and forgets them all on every write through it and on every
+// call of Invalidate. Concurrent identical reads share a single read of the
+// TODOStore.
+//
+// Changes of who can read which TODOs, made by ListService, must call
+// Invalidate, see ListService.OnAccessChange. Other writes made elsewhere
+// show up after TTL at most.
+// The TODOs returned are shared between callers, which must not modify them.
+type CachedTODOService struct {
+	next  TODOStore
+	lru   *cache.LRU
+	group singleflight.Group
+
+	// generation is part of every key, so that reads started before a write
+	// are neither joined nor found after it.
+	generation uint64
+
+	requests *metrics.Vec
+	loads    *metrics.Vec
+}
+
+// NewCachedTODOService returns a CachedTODOService around next, registering
+// its metrics to reg when not nil.
+func NewCachedTODOService(next TODOStore, cfg TODOCacheConfig, reg *metrics.Registry) *CachedTODOService {
+	s := &CachedTODOService{
+		next:     next,
+		lru:      cache.NewLRU(cfg.Size, cfg.TTL),
+		requests: metrics.NewCounterVec("todo_cache_requests_total", "Number of TODO reads by whether the cache held them.", "method", "result"),
+		loads:    metrics.NewCounterVec("todo_cache_loads_total", "Number of TODO reads passed through the cache.", "method"),
+	}
+	if reg != nil {
+		reg.MustRegister(s.requests, s.loads, metrics.NewGaugeFunc("todo_cache_entries", "Number of TODO reads held in the cache.", func() float64 {
+			return float64(s.lru.Len())
+		}))
+	}
+	return s
+}
+
+// read returns the result of load, kept under method and args for the user.
+// load returns the result with its cost, the number of TODOs it holds.
+func (s *CachedTODOService) read(ctx context.Context, method string, args []interface{}, load func(ctx context.Context) (interface{}, int, error)) (interface{}, error) {
+	user, _ := auth.UserFromContext(ctx)
+	key := fmt.Sprintf("%d\x00%t\x00%s\x00%s%v", atomic.LoadUint64(&s.generation), auth.IsInternal(ctx), user, method, args)
+	if v, ok := s.lru.Get(key); ok {
+		s.requests.Inc(method, "hit")
+		return v, nil
+	}
+	s.requests.Inc(method, "miss")
+
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		s.loads.Inc(method)
+		// the first caller going away must not fail the others
+		ctx, cancel := detach(ctx)
+		defer cancel()
+		v, cost, err := load(ctx)
+		if err == nil {
+			s.lru.Add(key, v, cost)
+		}
+		return v, err
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate forgets every read. It must follow the write, or a read in
+// between would keep what the write changes.
+func (s *CachedTODOService) Invalidate() {
+	atomic.AddUint64(&s.generation, 1)
+	s.lru.Purge()
+}
+
+// CreateTODO implements TODOStore interface.
+func (s *CachedTODOService) CreateTODO(ctx context.Context, subject, description string) (*model.TODO, error) {
+	return s.CreateListTODO(ctx, 0, subject, description)
+}
+
+// CreateListTODO implements TODOStore interface.
+func (s *CachedTODOService) CreateListTODO(ctx context.Context, listID int64, subject, description string) (*model.TODO, error) {
+	defer s.Invalidate()
+	return s.next.CreateListTODO(ctx, listID, subject, description)
+}
+
+// ReadTODO implements TODOStore interface.
+func (s *CachedTODOService) ReadTODO(ctx context.Context, prevID, size int64) ([]*model.TODO, error) {
+	return s.ReadListTODO(ctx, 0, prevID, size)
+}
+
+// ReadListTODO implements TODOStore interface.
+func (s *CachedTODOService) ReadListTODO(ctx context.Context, listID, prevID, size int64) ([]*model.TODO, error) {
+	v, err := s.read(ctx, "ReadListTODO", []interface{}{listID, prevID, size}, func(ctx context.Context) (interface{}, int, error) {
+		todos, err := s.next.ReadListTODO(ctx, listID, prevID, size)
+		return todos, len(todos) + 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*model.TODO), nil
+}
+
+// ReadListTODOVersions implements TODOStore interface.
+func (s *CachedTODOService) ReadListTODOVersions(ctx context.Context, listID, prevID, size int64) ([]*model.TODOVersion, error) {
+	v, err := s.read(ctx, "ReadListTODOVersions", []interface{}{listID, prevID, size}, func(ctx context.Context) (interface{}, int, error) {
+		versions, err := s.next.ReadListTODOVersions(ctx, listID, prevID, size)
+		return versions, len(versions) + 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*model.TODOVersion), nil
+}
+
+// GetTODO implements TODOStore interface.
+func (s *CachedTODOService) GetTODO(ctx context.Context, id int64) (*model.TODO, error) {
+	v, err := s.read(ctx, "GetTODO", []interface{}{id}, func(ctx context.Context) (interface{}, int, error) {
+		todo, err := s.next.GetTODO(ctx, id)
+		return todo, 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*model.TODO), nil
+}
+
+// GetTODOVersion implements TODOStore interface.
+func (s *CachedTODOService) GetTODOVersion(ctx context.Context, id int64) (*model.TODOVersion, error) {
+	v, err := s.read(ctx, "GetTODOVersion", []interface{}{id}, func(ctx context.Context) (interface{}, int, error) {
+		version, err := s.next.GetTODOVersion(ctx, id)
+		return version, 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*model.TODOVersion), nil
+}
+
+// LastTODODeletion implements TODOStore interface.
+func (s *CachedTODOService) LastTODODeletion(ctx context.Context) (time.Time, error) {
+	v, err := s.read(ctx, "LastTODODeletion", nil, func(ctx context.Context) (interface{}, int, error) {
+		deletedAt, err := s.next.LastTODODeletion(ctx)
+		return deletedAt, 1, err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return v.(time.Time), nil
+}
+
+// UpdateTODO implements TODOStore interface.
+func (s *CachedTODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
+	defer s.Invalidate()
+	return s.next.UpdateTODO(ctx, id, subject, description)
+}
+
+// DeleteTODO implements TODOStore interface.
+func (s *CachedTODOService) DeleteTODO(ctx context.Context, ids []int64) error {
+	defer s.Invalidate()
+	return s.next.DeleteTODO(ctx, ids)
+}
+
+// detachedContext keeps the values of a context, such as the user and the
+// span, but not its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// detach returns a context with the values and the deadline of ctx, which
+// is not canceled with ctx.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detachedContext{ctx}, deadline)
+	}
+	return context.WithCancel(detachedContext{ctx})
+}
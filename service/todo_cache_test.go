@@ -0,0 +1,226 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// fakeTODOStore counts the reads reaching it. Reads wait for release when it
+// is set.
+type fakeTODOStore struct {
+	service.TODOStore
+	reads   int64
+	release chan struct{}
+	err     error
+}
+
+func (s *fakeTODOStore) ReadListTODO(ctx context.Context, listID, prevID, size int64) ([]*model.TODO, error) {
+	atomic.AddInt64(&s.reads, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []*model.TODO{{ID: 1, Subject: "subject"}}, nil
+}
+
+func (s *fakeTODOStore) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
+	return &model.TODO{ID: id, Subject: subject}, nil
+}
+
+func TestCachedTODOService(t *testing.T) {
+	t.Parallel()
+
+	next := &fakeTODOStore{}
+	reg := metrics.NewRegistry()
+	svc := service.NewCachedTODOService(next, service.TODOCacheConfig{Size: 100, TTL: time.Hour}, reg)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+
+	steps := []struct {
+		name      string
+		ctx       context.Context
+		listID    int64
+		update    bool
+		wantReads int64
+	}{
+		{name: "first read", ctx: alice, wantReads: 1},
+		{name: "same read", ctx: alice, wantReads: 1},
+		{name: "other list", ctx: alice, listID: 1, wantReads: 2},
+		{name: "other user", ctx: bob, wantReads: 3},
+		{name: "update", ctx: bob, update: true, wantReads: 3},
+		{name: "read after update", ctx: alice, wantReads: 4},
+		{name: "read again", ctx: alice, wantReads: 4},
+	}
+	for _, step := range steps {
+		if step.update {
+			if _, err := svc.UpdateTODO(step.ctx, 1, "updated", ""); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := svc.ReadListTODO(step.ctx, step.listID, 0, 5); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt64(&next.reads); got != step.wantReads {
+			t.Errorf("%s: reads = %d, want %d", step.name, got, step.wantReads)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reg.Gather(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`todo_cache_requests_total{method="ReadListTODO",result="hit"} 2`,
+		`todo_cache_requests_total{method="ReadListTODO",result="miss"} 4`,
+		`todo_cache_loads_total{method="ReadListTODO"} 4`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestCachedTODOServiceSingleflight(t *testing.T) {
+	t.Parallel()
+
+	next := &fakeTODOStore{release: make(chan struct{})}
+	svc := service.NewCachedTODOService(next, service.TODOCacheConfig{Size: 100, TTL: time.Hour}, nil)
+	ctx := auth.ContextWithUser(context.Background(), "alice")
+
+	// the first caller going away does not fail the others
+	canceled, cancel := context.WithCancel(ctx)
+	first := make(chan error)
+	go func() {
+		_, err := svc.ReadListTODO(canceled, 0, 0, 5)
+		first <- err
+	}()
+	for atomic.LoadInt64(&next.reads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			todos, err := svc.ReadListTODO(ctx, 0, 0, 5)
+			if err == nil && len(todos) != 1 {
+				err = errors.New("unexpected TODOs")
+			}
+			errs <- err
+		}()
+	}
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first read err = %v, want context.Canceled", err)
+	}
+	// let the waiting reads join before the read ends
+	time.Sleep(50 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := atomic.LoadInt64(&next.reads); got != 1 {
+		t.Errorf("reads = %d, want 1", got)
+	}
+}
+
+func TestCachedTODOServiceError(t *testing.T) {
+	t.Parallel()
+
+	next := &fakeTODOStore{err: errors.New("database is locked")}
+	svc := service.NewCachedTODOService(next, service.TODOCacheConfig{Size: 100, TTL: time.Hour}, nil)
+	ctx := auth.ContextWithUser(context.Background(), "alice")
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.ReadListTODO(ctx, 0, 0, 5); err == nil {
+			t.Fatal("error was not returned")
+		}
+	}
+	if got := atomic.LoadInt64(&next.reads); got != 2 {
+		t.Errorf("reads = %d, want errors not cached", got)
+	}
+}
+
+func TestCachedTODOServiceAccessChange(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	lists := service.NewListService(d)
+	todos := service.NewCachedTODOService(service.NewTODOService(d), service.TODOCacheConfig{Size: 100, TTL: time.Hour}, nil)
+	lists.OnAccessChange(todos.Invalidate)
+
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+	list, err := lists.CreateList(alice, "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todos.CreateListTODO(alice, list.ID, "milk", ""); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		t.Helper()
+		got, err := todos.ReadTODO(bob, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(got)
+	}
+
+	steps := []struct {
+		name   string
+		change func() error
+		want   int
+	}{
+		{"not a member", nil, 0},
+		{"added", func() error {
+			_, err := lists.PutMember(alice, list.ID, "bob", model.PermissionViewer)
+			return err
+		}, 1},
+		{"removed", func() error { return lists.DeleteMember(alice, list.ID, "bob") }, 0},
+		{"invited", func() error {
+			invite, err := lists.CreateInvite(alice, list.ID, model.PermissionViewer, time.Hour)
+			if err != nil {
+				return err
+			}
+			_, err = lists.AcceptInvite(bob, invite.Token)
+			return err
+		}, 1},
+		{"list deleted", func() error { return lists.DeleteList(alice, list.ID) }, 0},
+	}
+	for _, s := range steps {
+		if s.change != nil {
+			if err := s.change(); err != nil {
+				t.Fatalf("%s: %v", s.name, err)
+			}
+		}
+		if got := count(); got != s.want {
+			t.Errorf("%s: bob reads %d TODOs, want %d", s.name, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// todoEventPruneInterval is how often events past the retention are deleted.
+const todoEventPruneInterval = time.Minute
+
+// A TODOEventConfig tunes TODOEventService. PollInterval is how often the log
+// is checked for new events, and Retention how long events are kept for
+// streams to resume from.
+type TODOEventConfig struct {
+	PollInterval time.Duration
+	Retention    time.Duration
+}
+
+// A TODOEventService reads the log of TODO changes, which triggers on DB
+// record, and tells the subscribers when it grew. Writers never wait for the
+// subscribers: each reads the log at its own pace, and a slow one only lags
+// behind.
+type TODOEventService struct {
+	// Heartbeat, when set before Run starts, is beaten on every poll.
+	Heartbeat *health.Heartbeat
+
+	db  *sql.DB
+	cfg TODOEventConfig
+
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTODOEventService returns new TODOEventService.
+func NewTODOEventService(db *sql.DB, cfg TODOEventConfig) *TODOEventService {
+	return &TODOEventService{
+		db:   db,
+		cfg:  cfg,
+		subs: map[chan struct{}]struct{}{},
+		done: make(chan struct{}),
+	}
+}
+
+// ReadTODOEvents reads up to size events after afterID, of the TODOs the user
+// in ctx can read now.
+func (s *TODOEventService) ReadTODOEvents(ctx context.Context, afterID, size int64) (_ []*model.TODOEvent, err error) {
+	ctx, span := startSpan(ctx, "TODOEventService.ReadTODOEvents")
+	span.SetAttribute("after_id", afterID)
+	defer func() { span.End(err) }()
+
+	const readFmt = `SELECT id, type, todo_id, subject, description, owner, list_id, todo_created, todo_updated, created_at
+		FROM todo_events WHERE id > ? AND %s ORDER BY id LIMIT ?`
+
+	cond, scope, err := todoScope(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	args := append(append([]interface{}{afterID}, scope...), size)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(readFmt, cond), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*model.TODOEvent{}
+	for rows.Next() {
+		var (
+			e      = &model.TODOEvent{}
+			listID sql.NullInt64
+		)
+		err := rows.Scan(&e.ID, &e.Type, &e.TODO.ID, &e.TODO.Subject, &e.TODO.Description, &e.TODO.Owner, &listID,
+			&e.TODO.CreatedAt, &e.TODO.UpdatedAt, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.TODO.ListID = listID.Int64
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// TODOEventRange returns the IDs of the oldest and the latest events kept,
+// both zero when there is none.
+func (s *TODOEventService) TODOEventRange(ctx context.Context) (first, last int64, err error) {
+	const read = `SELECT COALESCE(MIN(id), 0), COALESCE(MAX(id), 0) FROM todo_events`
+
+	err = s.db.QueryRowContext(ctx, read).Scan(&first, &last)
+	return first, last, err
+}
+
+// Subscribe returns a channel receiving a value whenever events were added,
+// and a function to stop receiving. Additions are coalesced while the
+// subscriber has not received the previous value.
+func (s *TODOEventService) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+func (s *TODOEventService) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already told
+		}
+	}
+}
+
+// Done returns a channel closed by Close, when the streams must end.
+func (s *TODOEventService) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close tells the streams to end, so that the server can shut down.
+func (s *TODOEventService) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Run polls the log for new events, and deletes the events past the
+// retention, until ctx is done.
+func (s *TODOEventService) Run(ctx context.Context) error {
+	const latest = `SELECT COALESCE(MAX(id), 0) FROM todo_events`
+
+	var last int64
+	if err := s.db.QueryRowContext(ctx, latest).Scan(&last); err != nil {
+		return err
+	}
+	poll := time.NewTicker(s.cfg.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(todoEventPruneInterval)
+	defer prune.Stop()
+
+	for {
+		s.Heartbeat.Beat()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-poll.C:
+			var id int64
+			if err := s.db.QueryRowContext(ctx, latest).Scan(&id); err != nil {
+				logger.FromContext(ctx).Warn("failed to poll todo events", "err", err)
+				continue
+			}
+			if id != last {
+				last = id
+				s.notify()
+			}
+		case <-prune.C:
+			if err := s.PruneTODOEvents(ctx, time.Now().Add(-s.cfg.Retention)); err != nil {
+				logger.FromContext(ctx).Warn("failed to prune todo events", "err", err)
+			}
+		}
+	}
+}
+
+// PruneTODOEvents deletes the events recorded before t.
+func (s *TODOEventService) PruneTODOEvents(ctx context.Context, t time.Time) error {
+	const prune = `DELETE FROM todo_events WHERE created_at < ?`
+
+	result, err := s.db.ExecContext(ctx, prune, sqliteTime(t))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		logger.FromContext(ctx).Debug("todo events pruned", "rows", n)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+func TestTODOEventService(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "todo_event.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	svc := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go svc.Run(ctx)
+	// let Run read the latest event before the writes
+	time.Sleep(50 * time.Millisecond)
+	wake, unsubscribe := svc.Subscribe()
+	defer unsubscribe()
+
+	todos := service.NewTODOService(d)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+	todo, err := todos.CreateTODO(alice, "alice's", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todos.CreateTODO(bob, "bob's", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := todos.UpdateTODO(alice, todo.ID, "alice's updated", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.DeleteTODO(alice, []int64{todo.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("subscriber was not told of the events")
+	}
+
+	events, err := svc.ReadTODOEvents(alice, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		typ     model.TODOEventType
+		subject string
+	}{
+		{model.TODOEventCreated, "alice's"},
+		{model.TODOEventUpdated, "alice's updated"},
+		{model.TODOEventDeleted, "alice's updated"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("alice read %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != want[i].typ || e.TODO.Subject != want[i].subject || e.TODO.ID != todo.ID {
+			t.Errorf("event %d = %s %q of %d, want %s %q of %d", i, e.Type, e.TODO.Subject, e.TODO.ID, want[i].typ, want[i].subject, todo.ID)
+		}
+	}
+
+	// resuming after the first event
+	events, err = svc.ReadTODOEvents(alice, events[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("alice read %d events after the first, want 2", len(events))
+	}
+
+	first, last, err := svc.TODOEventRange(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || last != 4 {
+		t.Errorf("range = %d, %d, want 1, 4", first, last)
+	}
+	if err := svc.PruneTODOEvents(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if first, last, err = svc.TODOEventRange(context.Background()); err != nil || first != 0 || last != 0 {
+		t.Errorf("range = %d, %d, %v after pruning, want 0, 0", first, last, err)
+	}
+}
+
+func TestWorkerHeartbeats(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "heartbeat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	webhooks := service.NewWebhookService(d, events, service.WebhookConfig{
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+		MaxAttempts:  1,
+		Concurrency:  1,
+		Retention:    time.Hour,
+	}, nil)
+	events.Heartbeat = health.NewHeartbeat(100 * time.Millisecond)
+	webhooks.Heartbeat = health.NewHeartbeat(100 * time.Millisecond)
+	workers := map[string]struct {
+		run       func(context.Context) error
+		heartbeat *health.Heartbeat
+	}{
+		"todo_events": {events.Run, events.Heartbeat},
+		"webhooks":    {webhooks.Run, webhooks.Heartbeat},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stopped := make(chan struct{}, len(workers))
+	for _, w := range workers {
+		go func(run func(context.Context) error) {
+			run(ctx)
+			stopped <- struct{}{}
+		}(w.run)
+	}
+	// longer than the heartbeats may be missed for
+	time.Sleep(300 * time.Millisecond)
+	for name, w := range workers {
+		if err := w.heartbeat.Check(context.Background()); err != nil {
+			t.Errorf("%s running: unexpected error, given = %v", name, err)
+		}
+	}
+
+	cancel()
+	for range workers {
+		<-stopped
+	}
+	time.Sleep(300 * time.Millisecond)
+	for name, w := range workers {
+		if err := w.heartbeat.Check(context.Background()); err == nil {
+			t.Errorf("%s stopped: no error", name)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package service
+
+import (
+	"context"
+
+	"github.com/TechBowl-japan/go-stations/tracing"
+)
+
+// startSpan starts the span of a service call querying the database. It does
+// nothing unless ctx is traced.
+func startSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name)
+	span.SetAttribute("db.system", "sqlite")
+	return ctx, span
+}
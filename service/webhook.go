@@ -0,0 +1,708 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/health"
+	"github.com/TechBowl-japan/go-stations/logger"
+	"github.com/TechBowl-japan/go-stations/metrics"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// webhookPageSize is the number of events or deliveries handled at a time.
+	webhookPageSize = 100
+	// webhookPruneInterval is how often finished deliveries past the
+	// retention are deleted.
+	webhookPruneInterval = time.Minute
+	// webhookResponseLimit bounds the response body read, so that the
+	// connection can be reused without reading a large one.
+	webhookResponseLimit = 64 << 10
+	// webhookUserAgent is sent with every delivery.
+	webhookUserAgent = "go-stations-webhook"
+)
+
+// A WebhookConfig tunes the deliveries of WebhookService. An attempt fails
+// after Timeout or on a non-2xx status, and is retried after Backoff, doubled
+// on every failure up to MaxBackoff, until MaxAttempts were made. A webhook
+// is disabled after DisableAfter failed attempts in a row. Up to Concurrency
+// attempts are made at a time, and finished deliveries are kept for
+// Retention. Receivers on private networks are only reached with
+// AllowPrivateNetworks.
+type WebhookConfig struct {
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	DisableAfter int
+	Concurrency  int
+	Retention    time.Duration
+
+	AllowPrivateNetworks bool
+}
+
+// privateNetworks are the ranges, besides loopback, link-local and
+// unspecified addresses, that webhooks must not reach: anyone may create
+// one and read how it was answered.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// privateIP reports whether ip is on a network webhooks must not reach.
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// errPrivateAddress fails the connections to private networks.
+var errPrivateAddress = errors.New("webhook receivers must not be on private networks")
+
+// A WebhookService implements webhooks posting the TODO events their owners
+// can read. Deliveries are queued on DB in the same transaction as the log
+// position they were made from, so that each event is delivered even across
+// restarts, at least once.
+type WebhookService struct {
+	// Heartbeat, when set before Run starts, is beaten on every poll and
+	// after every delivery attempt, so that a long queue does not look stuck.
+	Heartbeat *health.Heartbeat
+
+	db     *sql.DB
+	events *TODOEventService
+	cfg    WebhookConfig
+	client *http.Client
+
+	deliveries *metrics.Vec
+	disabled   *metrics.Vec
+}
+
+// NewWebhookService returns new WebhookService, woken by events, registering
+// its metrics to reg when not nil.
+func NewWebhookService(db *sql.DB, events *TODOEventService, cfg WebhookConfig, reg *metrics.Registry) *WebhookService {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		// checked again on connecting, as the names may resolve to other
+		// addresses by then
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would be connected to instead of the receivers
+	transport.Proxy = nil
+
+	s := &WebhookService{
+		db:     db,
+		events: events,
+		cfg:    cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// a redirect is the receiver's misconfiguration, not a success
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		deliveries: metrics.NewCounterVec("webhook_delivery_attempts_total", "Number of webhook delivery attempts by outcome.", "result"),
+		disabled:   metrics.NewCounterVec("webhooks_disabled_total", "Number of webhooks disabled after repeated failures."),
+	}
+	if reg != nil {
+		reg.MustRegister(s.deliveries, s.disabled)
+	}
+	return s
+}
+
+// WebhookSignature returns the hex HMAC-SHA256 of timestamp, a dot and body
+// keyed by secret, which deliveries carry as X-Webhook-Signature after
+// "sha256=". Receivers compute it on their own to check deliveries, and
+// reject old timestamps against replays.
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhook creates a webhook of the user on DB, with a random secret
+// when secret is empty. It is the only response telling the secret.
+func (s *WebhookService) CreateWebhook(ctx context.Context, rawURL, secret string, events []model.TODOEventType) (*model.Webhook, error) {
+	const insert = `INSERT INTO webhooks(owner, url, secret, events) VALUES(?, ?, ?, ?)`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if err := s.checkURL(ctx, rawURL); err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		var err error
+		if secret, err = newToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := s.db.ExecContext(ctx, insert, user, rawURL, secret, joinEventTypes(events))
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := s.readWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+
+	return webhook, nil
+}
+
+// ReadWebhooks reads the webhooks of the user on DB.
+func (s *WebhookService) ReadWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	const read = `SELECT ` + webhookColumns + ` FROM webhooks WHERE owner = ? ORDER BY id`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*model.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook replaces the URL and the events of the webhook of the user on
+// DB, and the secret unless empty. Activating a disabled webhook forgets its
+// failures, and deactivating one stops its deliveries until then. A nil
+// active keeps the webhook as it is.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id int64, rawURL, secret string, events []model.TODOEventType, active *bool) (*model.Webhook, error) {
+	const update = `UPDATE webhooks SET url = ?, events = ?,
+		secret = CASE WHEN ? = '' THEN secret ELSE ? END,
+		failures = CASE WHEN ? AND disabled_at IS NOT NULL THEN 0 ELSE failures END,
+		disabled_at = CASE WHEN ? IS NULL THEN disabled_at WHEN ? THEN NULL ELSE COALESCE(disabled_at, DATETIME('now')) END,
+		updated_at = DATETIME('now')
+		WHERE id = ? AND owner = ?`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if err := s.checkURL(ctx, rawURL); err != nil {
+		return nil, err
+	}
+
+	activate := sql.NullBool{Bool: active != nil && *active, Valid: active != nil}
+	result, err := s.db.ExecContext(ctx, update, rawURL, joinEventTypes(events), secret, secret, activate, activate, activate, id, user)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, &model.ErrNotFound{RowIDs: []int64{id}}
+	}
+
+	return s.readWebhook(ctx, id)
+}
+
+// DeleteWebhook deletes the webhook of the user together with its deliveries
+// on DB.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int64) error {
+	const (
+		deleteDeliveries = `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE id = ? AND owner = ?)`
+		deleteWebhook    = `DELETE FROM webhooks WHERE id = ? AND owner = ?`
+	)
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteDeliveries, id, user); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, deleteWebhook, id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &model.ErrNotFound{RowIDs: []int64{id}}
+	}
+
+	return tx.Commit()
+}
+
+// ReadWebhookDeliveries reads the deliveries of the webhook of the user on DB,
+// the latest first, up to size before prevID unless zero.
+func (s *WebhookService) ReadWebhookDeliveries(ctx context.Context, webhookID, prevID, size int64) ([]*model.WebhookDelivery, error) {
+	const read = `SELECT id, webhook_id, event_id, event_type, status, attempts, response_status, error, next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries WHERE webhook_id = ? AND (? = 0 OR id < ?) ORDER BY id DESC LIMIT ?`
+
+	// tells missing webhooks and the ones of others apart from empty logs
+	if _, err := s.readWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, webhookID, prevID, prevID, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*model.WebhookDelivery{}
+	for rows.Next() {
+		d := &model.WebhookDelivery{}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Error,
+			&d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// checkURL fails unless rawURL is an absolute http or https URL, of a host
+// resolving to public addresses only unless private networks are allowed.
+func (s *WebhookService) checkURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return &model.ErrInvalidWebhookURL{URL: rawURL, Reason: "must be an absolute http or https URL"}
+	}
+	if s.cfg.AllowPrivateNetworks {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return &model.ErrInvalidWebhookURL{URL: rawURL, Reason: "does not resolve"}
+	}
+	for _, addr := range addrs {
+		if privateIP(addr.IP) {
+			return &model.ErrInvalidWebhookURL{URL: rawURL, Reason: "must not reach private networks"}
+		}
+	}
+	return nil
+}
+
+// webhookColumns are the columns scanWebhook reads.
+const webhookColumns = `id, url, events, failures, disabled_at, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*model.Webhook, error) {
+	var (
+		webhook    = &model.Webhook{}
+		events     string
+		disabledAt sql.NullTime
+	)
+	err := row.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Failures, &disabledAt, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = splitEventTypes(events)
+	webhook.Active = !disabledAt.Valid
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+	return webhook, nil
+}
+
+// readWebhook reads the webhook of the user in ctx.
+func (s *WebhookService) readWebhook(ctx context.Context, id int64) (*model.Webhook, error) {
+	const read = `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = ? AND owner = ?`
+
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, read, id, user))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &model.ErrNotFound{RowIDs: []int64{id}}
+	}
+	return webhook, err
+}
+
+// joinEventTypes returns the events column of types, empty for all of them.
+func joinEventTypes(types []model.TODOEventType) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = string(t)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitEventTypes(s string) []model.TODOEventType {
+	types := []model.TODOEventType{}
+	if s == "" {
+		return types
+	}
+	for _, t := range strings.Split(s, ",") {
+		types = append(types, model.TODOEventType(t))
+	}
+	return types
+}
+
+// Run queues the deliveries of new events, makes the attempts due, and deletes
+// the deliveries past the retention, until ctx is done.
+func (s *WebhookService) Run(ctx context.Context) error {
+	wake, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+	poll := time.NewTicker(s.cfg.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(webhookPruneInterval)
+	defer prune.Stop()
+
+	for {
+		s.Heartbeat.Beat()
+		if err := s.enqueue(ctx); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Warn("failed to queue webhook deliveries", "err", err)
+		}
+		if err := s.deliver(ctx); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Warn("failed to deliver webhooks", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-poll.C:
+		case <-prune.C:
+			if err := s.PruneWebhookDeliveries(ctx, time.Now().Add(-s.cfg.Retention)); err != nil {
+				logger.FromContext(ctx).Warn("failed to prune webhook deliveries", "err", err)
+			}
+		}
+	}
+}
+
+// enqueue queues a delivery of each event after the cursor to every active
+// webhook taking it whose owner can read its TODO. Events pruned before the
+// cursor reached them are not delivered.
+func (s *WebhookService) enqueue(ctx context.Context) error {
+	for {
+		n, err := s.enqueuePage(ctx)
+		if err != nil || n < webhookPageSize {
+			return err
+		}
+	}
+}
+
+// enqueuePage queues the deliveries of a page of events, advancing the cursor
+// in the same transaction, and returns the number of events read. The cursor
+// only advances from where it was read, so that the events queued by another
+// process meanwhile, such as the one replaced by a restart, are not queued
+// twice.
+func (s *WebhookService) enqueuePage(ctx context.Context) (int, error) {
+	const (
+		cursor = `SELECT last_event_id FROM webhook_cursor WHERE id = 1`
+		insert = `INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload)
+			SELECT w.id, ?, ?, ? FROM webhooks w
+			WHERE w.disabled_at IS NULL AND w.created_at <= ?
+			AND (w.events = '' OR INSTR(',' || w.events || ',', ',' || ? || ',') > 0)
+			AND ((? IS NULL AND ? IN ('', w.owner)) OR ? IN (SELECT list_id FROM list_members WHERE user_id = w.owner))`
+		advance = `UPDATE webhook_cursor SET last_event_id = ? WHERE id = 1 AND last_event_id = ?`
+	)
+
+	var lastID int64
+	if err := s.db.QueryRowContext(ctx, cursor).Scan(&lastID); err != nil {
+		return 0, err
+	}
+	// the events of everyone are read, and filtered by owner below
+	events, err := s.events.ReadTODOEvents(auth.ContextInternal(ctx), lastID, webhookPageSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		listID := sql.NullInt64{Int64: e.TODO.ListID, Valid: e.TODO.ListID != 0}
+		_, err = tx.ExecContext(ctx, insert, e.ID, e.Type, string(payload), sqliteTime(e.CreatedAt), e.Type, listID, e.TODO.Owner, listID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.ExecContext(ctx, advance, events[len(events)-1].ID, lastID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		// rolled back, the events are read again from the cursor
+		logger.FromContext(ctx).Debug("webhook cursor moved while queueing", "last_event_id", lastID)
+		return len(events), nil
+	}
+
+	return len(events), tx.Commit()
+}
+
+// A webhookAttempt is a delivery due, with what posting it takes.
+type webhookAttempt struct {
+	id        int64
+	webhookID int64
+	url       string
+	secret    string
+	eventType model.TODOEventType
+	payload   []byte
+	attempts  int
+}
+
+// deliver makes the attempts due, Concurrency at a time, until none is left.
+func (s *WebhookService) deliver(ctx context.Context) error {
+	const due = `SELECT d.id, d.webhook_id, w.url, w.secret, d.event_type, d.payload, d.attempts
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? AND w.disabled_at IS NULL
+		ORDER BY d.id LIMIT ?`
+
+	for {
+		rows, err := s.db.QueryContext(ctx, due, sqliteTime(time.Now()), webhookPageSize)
+		if err != nil {
+			return err
+		}
+		attempts := []*webhookAttempt{}
+		for rows.Next() {
+			a := &webhookAttempt{}
+			if err := rows.Scan(&a.id, &a.webhookID, &a.url, &a.secret, &a.eventType, &a.payload, &a.attempts); err != nil {
+				rows.Close()
+				return err
+			}
+			attempts = append(attempts, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		sem := make(chan struct{}, s.cfg.Concurrency)
+		var wg sync.WaitGroup
+		for _, a := range attempts {
+			a := a
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				s.attempt(ctx, a)
+				s.Heartbeat.Beat()
+			}()
+		}
+		wg.Wait()
+
+		if len(attempts) < webhookPageSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// attempt posts the delivery and records the outcome.
+func (s *WebhookService) attempt(ctx context.Context, a *webhookAttempt) {
+	status, err := s.post(ctx, a)
+	if err != nil && ctx.Err() != nil {
+		// shutting down cut the attempt, which is made again after the restart
+		return
+	}
+	// an attempt made is recorded even when shutting down, or it would be
+	// repeated
+	ctx, cancel := detach(ctx)
+	defer cancel()
+	if err := s.record(ctx, a, status, err); err != nil {
+		logger.FromContext(ctx).Warn("failed to record webhook delivery", "delivery_id", a.id, "err", err)
+	}
+}
+
+// post sends the delivery and returns the response status, failing unless it
+// is 2xx.
+func (s *WebhookService) post(ctx context.Context, a *webhookAttempt) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(a.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set("X-Webhook-ID", strconv.FormatInt(a.webhookID, 10))
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(a.id, 10))
+	req.Header.Set("X-Webhook-Event", string(a.eventType))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+WebhookSignature(a.secret, timestamp, a.payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt on DB, scheduling the next one or
+// disabling the webhook after a failure.
+func (s *WebhookService) record(ctx context.Context, a *webhookAttempt, status int, attemptErr error) error {
+	const (
+		succeeded = `UPDATE webhook_deliveries SET status = 'succeeded', attempts = attempts + 1, response_status = ?, error = '',
+			updated_at = DATETIME('now') WHERE id = ?`
+		failed = `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_status = ?, error = ?,
+			next_attempt_at = ?, updated_at = DATETIME('now') WHERE id = ?`
+		reset   = `UPDATE webhooks SET failures = 0 WHERE id = ?`
+		fail    = `UPDATE webhooks SET failures = failures + 1 WHERE id = ?`
+		disable = `UPDATE webhooks SET disabled_at = DATETIME('now'), updated_at = DATETIME('now')
+			WHERE id = ? AND disabled_at IS NULL AND failures >= ?`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if attemptErr == nil {
+		if _, err := tx.ExecContext(ctx, succeeded, status, a.id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, reset, a.webhookID); err != nil {
+			return err
+		}
+		s.deliveries.Inc("succeeded")
+		return tx.Commit()
+	}
+
+	attempts := a.attempts + 1
+	result := model.DeliveryPending
+	if attempts >= s.cfg.MaxAttempts {
+		result = model.DeliveryFailed
+	}
+	next := time.Now().Add(s.backoff(attempts))
+	if _, err := tx.ExecContext(ctx, failed, result, status, attemptErr.Error(), sqliteTime(next), a.id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fail, a.webhookID); err != nil {
+		return err
+	}
+	disabled, err := tx.ExecContext(ctx, disable, a.webhookID, s.cfg.DisableAfter)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log := logger.FromContext(ctx)
+	log.Debug("webhook delivery failed", "webhook_id", a.webhookID, "delivery_id", a.id, "attempts", attempts, "err", attemptErr)
+	if result == model.DeliveryFailed {
+		s.deliveries.Inc("failed")
+	} else {
+		s.deliveries.Inc("retried")
+	}
+	if n, err := disabled.RowsAffected(); err == nil && n > 0 {
+		log.Warn("webhook disabled after repeated failures", "webhook_id", a.webhookID, "failures", s.cfg.DisableAfter)
+		s.disabled.Inc()
+	}
+	return nil
+}
+
+// backoff returns how long to wait after the attempts-th failed attempt.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	d := s.cfg.Backoff
+	for i := 1; i < attempts && d < s.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.cfg.MaxBackoff {
+		d = s.cfg.MaxBackoff
+	}
+	return d
+}
+
+// PruneWebhookDeliveries deletes the deliveries finished before t.
+func (s *WebhookService) PruneWebhookDeliveries(ctx context.Context, t time.Time) error {
+	const prune = `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND updated_at < ?`
+
+	result, err := s.db.ExecContext(ctx, prune, sqliteTime(t))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		logger.FromContext(ctx).Debug("webhook deliveries pruned", "rows", n)
+	}
+	return nil
+}
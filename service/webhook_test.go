@@ -0,0 +1,390 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A receiver records the deliveries it was posted, answering the statuses
+// in turn, and the last one from then on.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	events   []*model.TODOEvent
+	badSigs  int
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) *receiver {
+	rc := &receiver{secret: secret, statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		want := "sha256=" + service.WebhookSignature(rc.secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if r.Header.Get("X-Webhook-Signature") != want {
+			rc.badSigs++
+		}
+		status := rc.statuses[0]
+		if len(rc.statuses) > 1 {
+			rc.statuses = rc.statuses[1:]
+		}
+		if status == http.StatusOK {
+			e := &model.TODOEvent{}
+			if err := json.Unmarshal(body, e); err != nil || string(e.Type) != r.Header.Get("X-Webhook-Event") {
+				status = http.StatusBadRequest
+			} else {
+				rc.events = append(rc.events, e)
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) received() []*model.TODOEvent {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]*model.TODOEvent(nil), rc.events...)
+}
+
+// eventually retries cond until it holds or a few seconds passed.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestWebhookService(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "webhook.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	svc := service.NewWebhookService(d, events, service.WebhookConfig{
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+		MaxAttempts:  3,
+		Backoff:      time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+		DisableAfter: 4,
+		Concurrency:  2,
+		Retention:    time.Hour,
+
+		// the receivers listen on loopback
+		AllowPrivateNetworks: true,
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go events.Run(ctx)
+	go svc.Run(ctx)
+
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	bob := auth.ContextWithUser(context.Background(), "bob")
+
+	// alice's receiver fails twice before taking the deliveries
+	aliceRc := newReceiver(t, "alice-secret", http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	aliceHook, err := svc.CreateWebhook(alice, aliceRc.URL, "alice-secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceHook.Secret != "alice-secret" || !aliceHook.Active {
+		t.Errorf("created %+v", aliceHook)
+	}
+	// bob only takes deletions, of his TODOs
+	bobRc := newReceiver(t, "", http.StatusOK)
+	bobHook, err := svc.CreateWebhook(bob, bobRc.URL, "", []model.TODOEventType{model.TODOEventDeleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bobHook.Secret) == 0 {
+		t.Fatal("no secret was generated")
+	}
+	bobRc.mu.Lock()
+	bobRc.secret = bobHook.Secret
+	bobRc.mu.Unlock()
+
+	todos := service.NewTODOService(d)
+	todo, err := todos.CreateTODO(alice, "alice's", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.DeleteTODO(alice, []int64{todo.ID}); err != nil {
+		t.Fatal(err)
+	}
+	bobTODO, err := todos.CreateTODO(bob, "bob's", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.DeleteTODO(bob, []int64{bobTODO.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "alice's deliveries", func() bool { return len(aliceRc.received()) == 2 })
+	eventually(t, "bob's deliveries", func() bool { return len(bobRc.received()) == 1 })
+	// retries may reorder the deliveries
+	received := map[model.TODOEventType]int64{}
+	for _, e := range aliceRc.received() {
+		received[e.Type] = e.TODO.ID
+	}
+	if received[model.TODOEventCreated] != todo.ID || received[model.TODOEventDeleted] != todo.ID {
+		t.Errorf("alice received %v, want creation and deletion of %d", received, todo.ID)
+	}
+	if e := bobRc.received()[0]; e.Type != model.TODOEventDeleted || e.TODO.ID != bobTODO.ID {
+		t.Errorf("bob received %s of %d, want deleted of %d", e.Type, e.TODO.ID, bobTODO.ID)
+	}
+	if aliceRc.badSigs != 0 || bobRc.badSigs != 0 {
+		t.Errorf("%d and %d deliveries were badly signed", aliceRc.badSigs, bobRc.badSigs)
+	}
+
+	// the receivers answer before the outcome is recorded
+	var deliveries []*model.WebhookDelivery
+	eventually(t, "alice's deliveries to be recorded", func() bool {
+		deliveries, err = svc.ReadWebhookDeliveries(alice, aliceHook.ID, 0, 10)
+		return err == nil && len(deliveries) == 2 &&
+			deliveries[0].Status != model.DeliveryPending && deliveries[1].Status != model.DeliveryPending
+	})
+	attempts := 0
+	for _, d := range deliveries {
+		if d.Status != model.DeliverySucceeded || d.ResponseStatus != http.StatusOK {
+			t.Errorf("delivery %d is %s with %d", d.ID, d.Status, d.ResponseStatus)
+		}
+		attempts += d.Attempts
+	}
+	if len(deliveries) != 2 || attempts != 4 {
+		t.Errorf("%d deliveries took %d attempts, want 2 and 4", len(deliveries), attempts)
+	}
+	var notFound *model.ErrNotFound
+	if _, err := svc.ReadWebhookDeliveries(bob, aliceHook.ID, 0, 10); !errors.As(err, &notFound) {
+		t.Errorf("bob read alice's deliveries, err = %v", err)
+	}
+
+	// a receiver always failing gets its webhook disabled
+	if _, err := svc.UpdateWebhook(alice, aliceHook.ID, newReceiver(t, "", http.StatusServiceUnavailable).URL, "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := todos.CreateTODO(alice, "failing", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the webhook to be disabled", func() bool {
+		hooks, err := svc.ReadWebhooks(alice)
+		return err == nil && len(hooks) == 1 && !hooks[0].Active
+	})
+	// let the attempts in flight record
+	time.Sleep(50 * time.Millisecond)
+	deliveries, err = svc.ReadWebhookDeliveries(alice, aliceHook.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := 0
+	for _, d := range deliveries[:2] {
+		if d.ResponseStatus != http.StatusServiceUnavailable || d.Error == "" {
+			t.Errorf("delivery %d recorded %d %q", d.ID, d.ResponseStatus, d.Error)
+		}
+		if d.Attempts > 3 || (d.Status == model.DeliveryFailed) != (d.Attempts == 3) {
+			t.Errorf("delivery %d is %s after %d attempts", d.ID, d.Status, d.Attempts)
+		}
+		if d.Status == model.DeliveryPending {
+			pending++
+		}
+	}
+
+	// omitting active keeps it disabled
+	hook, err := svc.UpdateWebhook(alice, aliceHook.ID, aliceRc.URL, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Active || hook.DisabledAt == nil {
+		t.Errorf("updated without active %+v", hook)
+	}
+
+	// reactivating forgets the failures
+	active := true
+	hook, err = svc.UpdateWebhook(alice, aliceHook.ID, aliceRc.URL, "", nil, &active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hook.Active || hook.Failures != 0 || hook.DisabledAt != nil {
+		t.Errorf("reactivated %+v", hook)
+	}
+	eventually(t, "the pending deliveries", func() bool { return len(aliceRc.received()) == 2+pending })
+	if n := len(bobRc.received()); n != 1 {
+		t.Errorf("bob received %d deliveries, want 1", n)
+	}
+
+	if err := svc.DeleteWebhook(bob, aliceHook.ID); !errors.As(err, &notFound) {
+		t.Errorf("bob deleted alice's webhook, err = %v", err)
+	}
+	if err := svc.DeleteWebhook(alice, aliceHook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if hooks, err := svc.ReadWebhooks(alice); err != nil || len(hooks) != 0 {
+		t.Errorf("alice has %d webhooks after deleting, err = %v", len(hooks), err)
+	}
+}
+
+func TestWebhookServiceCursor(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "webhook.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// two processes queue the deliveries, as during a restart
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var svcs []*service.WebhookService
+	for i := 0; i < 2; i++ {
+		events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: time.Millisecond, Retention: time.Hour})
+		svc := service.NewWebhookService(d, events, service.WebhookConfig{
+			PollInterval: time.Millisecond,
+			Timeout:      time.Second,
+			MaxAttempts:  1,
+			Concurrency:  1,
+			Retention:    time.Hour,
+
+			AllowPrivateNetworks: true,
+		}, nil)
+		go events.Run(ctx)
+		go svc.Run(ctx)
+		svcs = append(svcs, svc)
+	}
+
+	alice := auth.ContextWithUser(context.Background(), "alice")
+	if _, err := svcs[0].CreateWebhook(alice, newReceiver(t, "", http.StatusOK).URL, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	todos := service.NewTODOService(d)
+	const n = 50
+	for i := 0; i < n; i++ {
+		if _, err := todos.CreateTODO(alice, "todo", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func() (deliveries, events int) {
+		err := d.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT event_id) FROM webhook_deliveries`).Scan(&deliveries, &events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deliveries, events
+	}
+	eventually(t, "the deliveries to be queued", func() bool {
+		_, events := count()
+		return events == n
+	})
+	// let both catch up
+	time.Sleep(50 * time.Millisecond)
+	if deliveries, events := count(); deliveries != n || events != n {
+		t.Errorf("queued %d deliveries of %d events, want %d", deliveries, events, n)
+	}
+}
+
+func TestWebhookServicePrivateNetworks(t *testing.T) {
+	t.Parallel()
+
+	d, err := db.NewDB(filepath.Join(t.TempDir(), "webhook_private.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	events := service.NewTODOEventService(d, service.TODOEventConfig{PollInterval: 10 * time.Millisecond, Retention: time.Hour})
+	cfg := service.WebhookConfig{
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      time.Second,
+		MaxAttempts:  1,
+		Backoff:      time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+		DisableAfter: 10,
+		Concurrency:  1,
+		Retention:    time.Hour,
+	}
+	svc := service.NewWebhookService(d, events, cfg, nil)
+	alice := auth.ContextWithUser(context.Background(), "alice")
+
+	cases := map[string]string{
+		"Loopback":        "http://127.0.0.1:8080/hook",
+		"Loopback IPv6":   "http://[::1]/hook",
+		"Localhost":       "http://localhost/hook",
+		"Private":         "https://10.1.2.3/hook",
+		"Private 172":     "http://172.20.0.1/hook",
+		"Private 192":     "http://192.168.1.1/hook",
+		"Link-local":      "http://169.254.169.254/latest/meta-data",
+		"Unspecified":     "http://0.0.0.0/hook",
+		"Mapped loopback": "http://[::ffff:127.0.0.1]/hook",
+		"Unique local":    "http://[fd00::1]/hook",
+		"Not http":        "ftp://example.com/hook",
+		"Relative":        "/hook",
+		"Unresolvable":    "http://host.invalid/hook",
+	}
+	for name, rawURL := range cases {
+		rawURL := rawURL
+		t.Run(name, func(t *testing.T) {
+			var badURL *model.ErrInvalidWebhookURL
+			if _, err := svc.CreateWebhook(alice, rawURL, "", nil); !errors.As(err, &badURL) {
+				t.Errorf("created a webhook to %s, err = %v", rawURL, err)
+			}
+		})
+	}
+
+	t.Run("Dial", func(t *testing.T) {
+		// a name resolving elsewhere on creation may resolve to a private
+		// address on delivery, which only the dialer can tell
+		rc := newReceiver(t, "", http.StatusOK)
+		allowing := cfg
+		allowing.AllowPrivateNetworks = true
+		hook, err := service.NewWebhookService(d, events, allowing, nil).CreateWebhook(alice, rc.URL, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go events.Run(ctx)
+		go svc.Run(ctx)
+		if _, err := service.NewTODOService(d).CreateTODO(alice, "alice's", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var deliveries []*model.WebhookDelivery
+		eventually(t, "the delivery to fail", func() bool {
+			deliveries, err = svc.ReadWebhookDeliveries(alice, hook.ID, 0, 10)
+			return err == nil && len(deliveries) == 1 && deliveries[0].Status == model.DeliveryFailed
+		})
+		if !strings.Contains(deliveries[0].Error, "private networks") {
+			t.Errorf("delivery failed with %q", deliveries[0].Error)
+		}
+		if n := len(rc.received()); n != 0 {
+			t.Errorf("receiver was posted %d deliveries", n)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// An Exporter sends ended spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// A JSONExporter writes every span as one line of JSON.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+// NewJSONExporter returns a JSONExporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// OpenJSONExporter returns a JSONExporter appending to the file at path, or
+// writing to stdout when path is empty or "-".
+func OpenJSONExporter(path string) (*JSONExporter, error) {
+	if path == "" || path == "-" {
+		return NewJSONExporter(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONExporter{w: f, c: f}, nil
+}
+
+type jsonSpan struct {
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Export implements Exporter interface.
+func (e *JSONExporter) Export(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		js := &jsonSpan{
+			Name:       s.Name,
+			Kind:       s.Kind.String(),
+			TraceID:    s.TraceID.String(),
+			SpanID:     s.SpanID.String(),
+			Start:      s.Start,
+			End:        s.End,
+			Duration:   float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			Attributes: s.Attributes,
+		}
+		if s.ParentID.IsValid() {
+			js.ParentID = s.ParentID.String()
+		}
+		if s.Err != nil {
+			js.Error = s.Err.Error()
+		}
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown implements Exporter interface. It closes the file opened by
+// OpenJSONExporter.
+func (e *JSONExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.c == nil {
+		return nil
+	}
+	return e.c.Close()
+}
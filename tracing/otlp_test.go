@@ -0,0 +1,147 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/tracing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		value   string
+		wantErr bool
+		sampled bool
+	}{
+		"Sampled":           {value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		"Not sampled":       {value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		"Future version":    {value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", sampled: true},
+		"Extra on v00":      {value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		"Invalid version":   {value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		"Zero trace ID":     {value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		"Uppercase":         {value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		"Short span ID":     {value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", wantErr: true},
+		"Empty":             {value: "", wantErr: true},
+		"Not hex":           {value: "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", wantErr: true},
+		"Surrounding space": {value: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", sampled: true},
+	}
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sc, err := tracing.ParseTraceparent(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Sampled != tc.sampled {
+				t.Errorf("sampled = %t, want %t", sc.Sampled, tc.sampled)
+			}
+			if got := sc.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("trace ID = %s", got)
+			}
+		})
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+		Status       struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	var (
+		mu    sync.Mutex
+		spans []span
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected export request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error("failed to decode export request, err =", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	tracer := tracing.NewTracer(tracing.NewOTLPExporter(collector.URL, "test", nil))
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	h := http.Header{}
+	h.Set(tracing.TraceparentHeader, traceparent)
+	remote, ok := tracing.Extract(h)
+	if !ok {
+		t.Fatal("failed to extract traceparent")
+	}
+	ctx, server := tracer.Start(tracing.ContextWithRemote(context.Background(), remote), "GET /todos", tracing.SpanKindServer)
+	_, child := tracing.Start(ctx, "TODOService.ReadListTODO")
+	child.End(errors.New("boom"))
+	server.End(nil)
+
+	out := http.Header{}
+	tracing.Inject(ctx, out)
+	if got := out.Get(tracing.TraceparentHeader); got != server.SpanContext().Traceparent() {
+		t.Errorf("injected traceparent = %s, want %s", got, server.SpanContext().Traceparent())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal("failed to shut down tracer, err =", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+	byName := map[string]span{}
+	for _, s := range spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s has trace ID %s", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	srv, svc := byName["GET /todos"], byName["TODOService.ReadListTODO"]
+	if srv.ParentSpanID != "00f067aa0ba902b7" || srv.Kind != 2 {
+		t.Errorf("server span parent = %s, kind = %d", srv.ParentSpanID, srv.Kind)
+	}
+	if svc.ParentSpanID != srv.SpanID {
+		t.Errorf("service span parent = %s, want %s", svc.ParentSpanID, srv.SpanID)
+	}
+	if svc.Status.Code != 2 || svc.Status.Message != "boom" {
+		t.Errorf("service span status = %+v", svc.Status)
+	}
+}
@@ -0,0 +1,419 @@
+// Package tracing records spans of requests and service calls and propagates
+// them with the W3C Trace Context headers.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header names defined by W3C Trace Context.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// A TraceID identifies a trace across services.
+type TraceID [16]byte
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// A SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// A SpanContext is the part of a span propagated to other services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether sc has both a trace ID and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Values of unknown
+// future versions are accepted as long as their first four fields parse.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errors.New("tracing: malformed traceparent")
+	}
+	version, err := decodeHex(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, errors.New("tracing: unsupported traceparent version")
+	}
+	traceID, err := decodeHex(parts[1])
+	if err != nil {
+		return sc, err
+	}
+	spanID, err := decodeHex(parts[2])
+	if err != nil {
+		return sc, err
+	}
+	flags, err := decodeHex(parts[3])
+	if err != nil {
+		return sc, err
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&0x01 != 0
+	if !sc.IsValid() {
+		return sc, errors.New("tracing: traceparent has zero trace or span ID")
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex only, as Trace Context requires.
+func decodeHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, errors.New("tracing: traceparent must be lowercase hex")
+	}
+	return hex.DecodeString(s)
+}
+
+// Extract returns the span context propagated in h.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.TraceState = h.Get(TracestateHeader)
+	return sc, true
+}
+
+// Inject writes the span context of ctx to h for an outgoing request.
+func Inject(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	h.Set(TraceparentHeader, span.ctx.Traceparent())
+	if span.ctx.TraceState != "" {
+		h.Set(TracestateHeader, span.ctx.TraceState)
+	}
+}
+
+// A SpanKind tells the role of a span in a trace.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// A Span records one timed operation. The methods of a nil *Span do nothing,
+// so code may record spans without checking whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	parent SpanID
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+// SpanContext returns the propagated part of s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttribute records key with value on s. value should be a string, bool,
+// integer or float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks s as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End finishes s, marking it failed when err is not nil, and hands it to the
+// exporter. Only the first call has an effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.SetError(err)
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.ctx.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// A SpanData is an ended span as seen by exporters.
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        error
+}
+
+func (s *Span) data() *SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attributes := make(map[string]interface{}, len(s.attributes))
+	for k, v := range s.attributes {
+		attributes[k] = v
+	}
+	return &SpanData{
+		Name:       s.name,
+		Kind:       s.kind,
+		TraceID:    s.ctx.TraceID,
+		SpanID:     s.ctx.SpanID,
+		ParentID:   s.parent,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: attributes,
+		Err:        s.err,
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of parent that carries span.
+func ContextWithSpan(parent context.Context, span *Span) context.Context {
+	return context.WithValue(parent, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote returns a copy of parent that carries the span context
+// received from another service, to be used as the parent of the next span.
+func ContextWithRemote(parent context.Context, sc SpanContext) context.Context {
+	return context.WithValue(parent, remoteKey{}, sc)
+}
+
+// Start starts a child of the span carried by ctx with the same tracer. When
+// ctx carries no span, nothing is traced and the returned span is nil.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, SpanKindInternal)
+}
+
+// A Tracer starts spans and exports the sampled ones in batches.
+type Tracer struct {
+	exporter Exporter
+	spans    chan *Span
+	flush    chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+const (
+	batchSize     = 256
+	batchInterval = 5 * time.Second
+	queueSize     = 4096
+)
+
+// NewTracer returns a Tracer exporting to exp. A nil exp disables exporting
+// while trace context is still propagated.
+func NewTracer(exp Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exp,
+		spans:    make(chan *Span, queueSize),
+		flush:    make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span named name. Its parent is the span carried by ctx or
+// else the remote span context; without either a new trace is started.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.ctx = parent.ctx
+		span.parent = parent.ctx.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.ctx = remote
+		span.parent = remote.SpanID
+	} else {
+		rand.Read(span.ctx.TraceID[:])
+		span.ctx.Sampled = true
+	}
+	rand.Read(span.ctx.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// enqueue drops span rather than blocking the request when the exporter
+// falls behind or the tracer is shut down.
+func (t *Tracer) enqueue(span *Span) {
+	if t.exporter == nil {
+		return
+	}
+	select {
+	case <-t.stop:
+	case t.spans <- span:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []*SpanData
+	export := func() {
+		for len(t.spans) > 0 {
+			batch = append(batch, (<-t.spans).data())
+		}
+		if len(batch) == 0 || t.exporter == nil {
+			batch = nil
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), batchInterval)
+		defer cancel()
+		// a failed batch is dropped; tracing must not hold the server back
+		_ = t.exporter.Export(ctx, batch)
+		batch = nil
+	}
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span.data())
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			export()
+			close(flushed)
+		case <-t.stop:
+			export()
+			return
+		}
+	}
+}
+
+// Flush exports the spans ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and shuts the exporter down. Spans
+// ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}